@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+const (
+	defaultDeletionGrace = 30 * 24 * time.Hour
+	reauthWindow = 10 * time.Minute	//How recent a login has to be to stand in for the password, on accounts that have none
+)
+
+func (cfg *apiConfig) deleteAccountHandler(w http.ResponseWriter, req *http.Request) {	//Schedules the caller's account for deletion after the grace period, logging them out everywhere. Logging in again before then cancels it
+	type httpRequest struct {	//Any one of these, or none after a fresh login
+		Password string `json:"password"`
+		Code string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	userId := principalFrom(req.Context()).UserID
+
+	request := httpRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, 400, "Invalid JSON payload")
+		return
+	}
+
+	user, err := cfg.db.GetUserFromID(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 500, "Error finding user")
+		return
+	}
+	accountKey := strings.ToLower(user.Email)	//Guesses count against the same limits as login attempts
+	if !cfg.allowLogin(w, req, accountKey) {
+		return
+	}
+	start := time.Now()
+	if !cfg.reauthenticated(req, user, request.Password, request.Code, request.RecoveryCode) {
+		if request.Password != "" {
+			auth.PadPasswordCheck(start)
+		}
+		cfg.failLogin(req, auditUserDelete, accountKey, user.ID, "reauthentication_failed")
+		respondWithError(w, 401, "Confirm it's you with your password or a two-factor code. Accounts without a password can log in again instead")
+		return
+	}
+	cfg.accountLimiter.Reset(accountKey)
+
+	if _, err := cfg.db.SoftDeleteUser(req.Context(), user.ID); err != nil {	//Also bumps the token version
+		fmt.Printf("Error deleting user %s: %s\n", user.ID, err)
+		respondWithError(w, 500, "Error deleting account")
+		return
+	}
+	cfg.revocations.Forget(user.ID)
+	if err := cfg.db.RevokeUserTokens(req.Context(), user.ID); err != nil {
+		fmt.Printf("Error revoking sessions for deleted user %s: %s\n", user.ID, err)
+	}
+	if err := cfg.db.RevokeUserPersonalAccessTokens(req.Context(), user.ID); err != nil {
+		fmt.Printf("Error revoking personal access tokens for deleted user %s: %s\n", user.ID, err)
+	}
+
+	purgeAt := time.Now().Add(cfg.deletionGrace)
+	cfg.audit(req, auditEntry{Action: auditUserDelete, TargetType: "user", TargetID: user.ID.String(), Details: map[string]any{"purge_at": purgeAt}})
+	cfg.clearSessionCookies(w)
+	respondWithJSON(w, 202, struct {
+		Status string `json:"status"`
+		PurgeAt time.Time `json:"purge_at"`
+	}{
+		Status: "Account scheduled for deletion, log in before purge_at to cancel",
+		PurgeAt: purgeAt,
+	})
+}
+
+func (cfg *apiConfig) reauthenticated(req *http.Request, user database.User, password, code, recoveryCode string) bool {	//Whether the caller just proved they own the account, by password, TOTP or recovery code. Accounts created through single sign-on have no password their owner knows, so for them a login within reauthWindow does too
+	if password != "" {
+		return auth.HasPassword(user.HashedPassword) && auth.CheckPasswordHash(user.HashedPassword, password) == nil
+	}
+	if code != "" || recoveryCode != "" {
+		return user.TotpEnabledAt.Valid && cfg.checkSecondFactor(req, user, code, recoveryCode)
+	}
+	if auth.HasPassword(user.HashedPassword) {	//Otherwise a token stolen soon after login could delete the account
+		return false
+	}
+	authTime := principalFrom(req.Context()).AuthTime
+	return !authTime.IsZero() && time.Since(authTime) < reauthWindow
+}
+
+func (cfg *apiConfig) restoreAccount(req *http.Request, userID uuid.UUID) {	//Cancels a pending deletion when its owner logs back in
+	restored, err := cfg.db.RestoreUser(req.Context(), userID)
+	if err != nil {
+		fmt.Printf("Error restoring user %s: %s\n", userID, err)
+		return
+	}
+	if restored > 0 {
+		cfg.audit(req, auditEntry{Action: auditUserRestore, ActorID: userID, TargetType: "user", TargetID: userID.String()})
+	}
+}
+
+func (cfg *apiConfig) purgeDeletedUsers(interval time.Duration) {	//Hard deletes accounts whose grace period has run out. Their rows in other tables go with them through ON DELETE CASCADE
+	for range time.Tick(interval) {
+		ctx := context.Background()
+		cutoff := sql.NullTime{Time: time.Now().Add(-cfg.deletionGrace), Valid: true}
+		purged, err := cfg.db.PurgeDeletedUsers(ctx, cutoff)
+		if err != nil {
+			fmt.Printf("Error purging deleted users: %s\n", err)
+			continue
+		}
+		for _, user := range purged {
+			cfg.revocations.Forget(user.ID)
+			if user.AvatarKey.Valid {	//Files aren't covered by the cascade
+				cfg.deleteAvatar(ctx, user.AvatarKey.String)
+			}
+			cfg.recordAudit(ctx, auditEntry{Action: auditUserPurge, TargetType: "user", TargetID: user.ID.String()}, "", "")
+		}
+		if len(purged) > 0 {
+			fmt.Printf("Purged %d deleted users\n", len(purged))
+		}
+	}
+}
+
+type exportProfile struct {
+	ID uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Email string `json:"email"`
+	EmailVerified bool `json:"email_verified"`
+	Role string `json:"role"`
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+	Profile
+}
+
+type exportSubscriptionEvent struct {
+	Event string `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func (cfg *apiConfig) exportAccountHandler(w http.ResponseWriter, req *http.Request) {	//Streams a ZIP of everything stored about the caller, one JSON file per kind of data
+	userId := principalFrom(req.Context()).UserID
+
+	user, err := cfg.db.GetUserFromID(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 500, "Error finding user")
+		return
+	}
+	chirps, err := cfg.db.GetChirpsFromAuthor(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 500, "Error getting chirps")
+		return
+	}
+	tokens, err := cfg.db.ListSessionHistory(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 500, "Error getting sessions")
+		return
+	}
+	events, err := cfg.db.ListSubscriptionEvents(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 500, "Error getting subscription history")
+		return
+	}
+
+	files := []struct {	//Gathered up front so database errors can still get a proper response
+		name string
+		data any
+	}{
+		{"profile.json", exportProfile{
+			ID: user.ID,
+			CreatedAt: user.CreatedAt,
+			UpdatedAt: user.UpdatedAt,
+			Email: user.Email,
+			EmailVerified: user.EmailVerifiedAt.Valid,
+			Role: user.Role,
+			TwoFactorEnabled: user.TotpEnabledAt.Valid,
+			Profile: cfg.userProfile(user),
+		}},
+		{"chirps.json", exportChirps(chirps)},
+		{"sessions.json", exportSessions(tokens)},
+		{"subscription.json", struct {
+			Current *Subscription `json:"current"`
+			Events []exportSubscriptionEvent `json:"events"`
+		}{
+			Current: cfg.userSubscription(req.Context(), userId),
+			Events: exportSubscriptionEvents(events),
+		}},
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%s.zip"`, userId))
+	w.WriteHeader(200)
+	archive := zip.NewWriter(w)
+	for _, f := range files {
+		entry, err := archive.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			fmt.Printf("Error writing export for user %s: %s\n", userId, err)
+			return
+		}
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(f.data); err != nil {
+			fmt.Printf("Error writing export for user %s: %s\n", userId, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		fmt.Printf("Error writing export for user %s: %s\n", userId, err)
+		return
+	}
+	cfg.audit(req, auditEntry{Action: auditUserExport, TargetType: "user", TargetID: userId.String()})
+}
+
+func exportChirps(chirps []database.Chirp) []Chirp {
+	res := make([]Chirp, 0, len(chirps))
+	for _, c := range chirps {
+		res = append(res, Chirp{
+			ID: c.ID,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+			Body: c.Body,
+			UserID: c.UserID,
+		})
+	}
+	return res
+}
+
+func exportSessions(tokens []database.RefreshToken) []Session {	//One entry per session, including ones that have ended
+	res := make([]Session, 0, len(tokens))
+	for _, t := range tokens {
+		s := Session{
+			ID: t.FamilyID,
+			Name: t.Name,
+			CreatedAt: t.SessionCreatedAt,
+			LastUsedAt: t.LastUsedAt,
+			ExpiresAt: t.ExpiresAt,
+			UserAgent: t.UserAgent,
+			IPAddress: t.IpAddress,
+		}
+		if t.RevokedAt.Valid {
+			revokedAt := t.RevokedAt.Time
+			s.RevokedAt = &revokedAt
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+func exportSubscriptionEvents(events []database.SubscriptionEvent) []exportSubscriptionEvent {
+	res := make([]exportSubscriptionEvent, 0, len(events))
+	for _, e := range events {
+		payload := json.RawMessage(e.Payload)
+		if !json.Valid(payload) {
+			payload = json.RawMessage("null")
+		}
+		res = append(res, exportSubscriptionEvent{Event: e.Event, CreatedAt: e.CreatedAt, Payload: payload})
+	}
+	return res
+}
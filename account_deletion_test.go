@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+func TestExportSessions(t *testing.T) {
+	revokedAt := time.Now()
+	sessions := exportSessions([]database.RefreshToken{
+		{FamilyID: uuid.New(), TokenHash: "secret"},
+		{FamilyID: uuid.New(), RevokedAt: sql.NullTime{Time: revokedAt, Valid: true}},
+	})
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, wanted 2", len(sessions))
+	}
+	if sessions[0].RevokedAt != nil {
+		t.Error("active session marked revoked")
+	}
+	if sessions[1].RevokedAt == nil || !sessions[1].RevokedAt.Equal(revokedAt) {
+		t.Errorf("revoked session has revoked_at %v", sessions[1].RevokedAt)
+	}
+}
+
+func TestExportSubscriptionEvents(t *testing.T) {
+	events := exportSubscriptionEvents([]database.SubscriptionEvent{
+		{Event: "user.upgraded", Payload: `{"data":{"plan":"chirpy_red"}}`},
+		{Event: "user.canceled", Payload: "not json"},
+	})
+	if string(events[0].Payload) != `{"data":{"plan":"chirpy_red"}}` {
+		t.Errorf("payload changed: %s", events[0].Payload)
+	}
+	if string(events[1].Payload) != "null" {
+		t.Errorf("invalid payload not replaced: %s", events[1].Payload)
+	}
+}
+
+func TestDeleteAccountHandler(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB(t, cfg)
+	hash, err := auth.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withPassword := db.addUser(database.User{Email: "user@example.com", HashedPassword: hash})
+	ssoOnly := db.addUser(database.User{Email: "sso@example.com", HashedPassword: auth.NoPassword})
+	handler := cfg.requireAuth("", cfg.deleteAccountHandler)
+
+	send := func(user database.User, authTime time.Time, body string) int {
+		token, err := auth.MakeJWT(auth.AccessToken{UserID: user.ID, Role: user.Role, Version: user.TokenVersion, AuthTime: authTime}, cfg.keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("DELETE", "/api/users/me", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send(ssoOnly, time.Now().Add(-time.Hour), `{}`); code != 401 {
+		t.Errorf("stale login without a password got status %d, wanted 401", code)
+	}
+	if code := send(withPassword, time.Now(), `{}`); code != 401 {	//A fresh token alone could have been stolen
+		t.Errorf("fresh login on an account with a password got status %d, wanted 401", code)
+	}
+	if code := send(withPassword, time.Now(), `{"password":"wrong"}`); code != 401 {
+		t.Errorf("wrong password got status %d, wanted 401", code)
+	}
+	if db.user(withPassword.ID).DeletedAt.Valid {
+		t.Fatal("account deleted without reauthentication")
+	}
+
+	if code := send(withPassword, time.Now(), `{"password":"correct horse battery staple"}`); code != 202 {
+		t.Fatalf("correct password got status %d, wanted 202", code)
+	}
+	if !db.user(withPassword.ID).DeletedAt.Valid {
+		t.Fatal("account not marked deleted")
+	}
+	if code := send(withPassword, time.Now(), `{"password":"correct horse battery staple"}`); code != 401 {	//Tokens minted before the deletion carry the old version
+		t.Errorf("deleted account's token got status %d, wanted 401", code)
+	}
+
+	if code := send(ssoOnly, time.Now(), `{}`); code != 202 {
+		t.Errorf("fresh login on an account without a password got status %d, wanted 202", code)
+	}
+}
+
+func TestDeleteAccountThrottled(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB(t, cfg)
+	hash, err := auth.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := db.addUser(database.User{Email: "user@example.com", HashedPassword: hash})
+	token, err := auth.MakeJWT(auth.AccessToken{UserID: user.ID, Role: user.Role}, cfg.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := cfg.requireAuth("", cfg.deleteAccountHandler)
+
+	code := 0
+	for range 7 {
+		req := httptest.NewRequest("DELETE", "/api/users/me", strings.NewReader(`{"password":"guess"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		code = rec.Code
+	}
+	if code != 429 {
+		t.Errorf("repeated wrong passwords got status %d, wanted 429", code)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+
+	auditLogin = "login"
+	auditLogin2FA = "login.2fa"
+	auditLoginOIDC = "login.oidc"
+	auditLoginMagic = "login.magic_link"
+	auditTokenRefresh = "token.refresh"
+	auditTokenRevoke = "token.revoke"
+	auditSessionRevoke = "session.revoke"
+	auditSessionRevokeAll = "session.revoke_all"
+	auditUserCreate = "user.create"
+	auditUserDelete = "user.delete"	//Soft delete, the account is purged after the grace period
+	auditUserRestore = "user.restore"
+	auditUserPurge = "user.purge"
+	auditUserExport = "user.export"
+	auditPasswordChange = "password.change"
+	auditPasswordReset = "password.reset"
+	auditEmailChange = "email.change"
+	auditEmailVerify = "email.verify"
+	auditProfileUpdate = "profile.update"
+	auditAvatarUpdate = "avatar.update"
+	auditAvatarDelete = "avatar.delete"
+	auditTwoFactorEnable = "2fa.enable"
+	auditTwoFactorDisable = "2fa.disable"
+	auditTokenCreate = "pat.create"
+	auditTokenDelete = "pat.revoke"
+	auditChirpDelete = "chirp.delete"
+	auditSubscription = "subscription"	//Suffixed with the Polka event, e.g. subscription.user.canceled
+	auditAdminReset = "admin.reset"
+	auditAdminRoleChange = "admin.role_change"
+	auditAdminBan = "admin.ban"
+	auditAdminUnban = "admin.unban"
+	auditAdminWebhookReplay = "admin.webhook_replay"
+	auditAdminImpersonate = "admin.impersonate"
+	auditImpersonatedRequest = "impersonation.request"	//Every request made with an impersonation token
+)
+
+type auditEntry struct {	//One security relevant action. Outcome defaults to success and ActorID to whoever made the request
+	Action string
+	Outcome string
+	ActorID uuid.UUID
+	TargetType string	//What was acted on, such as user, session or chirp
+	TargetID string
+	Details map[string]any
+}
+
+func (cfg *apiConfig) audit(req *http.Request, entry auditEntry) {	//Records entry with the caller's IP and user agent
+	if entry.ActorID == uuid.Nil {
+		entry.ActorID = principalFrom(req.Context()).UserID
+	}
+	if p := principalFrom(req.Context()); p.Impersonated() && entry.ActorID == p.UserID {	//Actions taken while impersonating belong to the admin
+		entry.ActorID = p.ImpersonatorID
+		if entry.Details == nil {
+			entry.Details = map[string]any{}
+		}
+		entry.Details["impersonating"] = p.UserID
+	}
+	cfg.recordAudit(context.WithoutCancel(req.Context()), entry, clientIP(req), req.UserAgent())	//Still written if the client hangs up
+}
+
+func (cfg *apiConfig) recordAudit(ctx context.Context, entry auditEntry, ip, userAgent string) {	//Failures are logged rather than failing the action being audited
+	if entry.Outcome == "" {
+		entry.Outcome = auditSuccess
+	}
+	details := []byte("{}")
+	if len(entry.Details) > 0 {
+		var err error
+		if details, err = json.Marshal(entry.Details); err != nil {
+			details = []byte("{}")
+		}
+	}
+	if err := cfg.db.CreateAuditEvent(ctx, database.CreateAuditEventParams{
+		ID: uuid.New(),
+		Action: entry.Action,
+		Outcome: entry.Outcome,
+		ActorID: uuid.NullUUID{UUID: entry.ActorID, Valid: entry.ActorID != uuid.Nil},
+		TargetType: entry.TargetType,
+		TargetID: entry.TargetID,
+		IpAddress: ip,
+		UserAgent: userAgent,
+		Details: string(details),
+	}); err != nil {
+		fmt.Printf("Error writing audit event %s: %s\n", entry.Action, err)
+	}
+}
+
+type AuditEvent struct {
+	ID uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Action string `json:"action"`
+	Outcome string `json:"outcome"`
+	ActorID *uuid.UUID `json:"actor_id"`
+	TargetType string `json:"target_type,omitempty"`
+	TargetID string `json:"target_id,omitempty"`
+	IPAddress string `json:"ip_address"`
+	UserAgent string `json:"user_agent"`
+	Details json.RawMessage `json:"details"`
+}
+
+func toAuditEvent(e database.AuditEvent) AuditEvent {
+	event := AuditEvent{
+		ID: e.ID,
+		CreatedAt: e.CreatedAt,
+		Action: e.Action,
+		Outcome: e.Outcome,
+		TargetType: e.TargetType,
+		TargetID: e.TargetID,
+		IPAddress: e.IpAddress,
+		UserAgent: e.UserAgent,
+		Details: json.RawMessage(e.Details),
+	}
+	if e.ActorID.Valid {
+		event.ActorID = &e.ActorID.UUID
+	}
+	if !json.Valid(event.Details) {
+		event.Details = json.RawMessage("{}")
+	}
+	return event
+}
+
+func encodeAuditCursor(e database.AuditEvent) string {	//Position after e in newest first order
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(e.CreatedAt.UnixMicro(), 10) + "_" + e.ID.String()))
+}
+
+func decodeAuditCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.UUID{}, err
+	}
+	micros, idString, ok := strings.Cut(string(raw), "_")
+	if !ok {
+		return time.Time{}, uuid.UUID{}, fmt.Errorf("malformed cursor")
+	}
+	n, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return time.Time{}, uuid.UUID{}, err
+	}
+	id, err := uuid.Parse(idString)
+	if err != nil {
+		return time.Time{}, uuid.UUID{}, err
+	}
+	return time.UnixMicro(n).UTC(), id, nil
+}
+
+func auditFilters(req *http.Request) (database.ListAuditEventsParams, error) {	//Reads action, outcome, actor_id, target_id, since, until and cursor from the query string
+	query := req.URL.Query()
+	params := database.ListAuditEventsParams{}
+	if v := query.Get("action"); v != "" {
+		params.Action = sql.NullString{String: v, Valid: true}
+	}
+	if v := query.Get("outcome"); v != "" {
+		if v != auditSuccess && v != auditFailure {
+			return params, fmt.Errorf("outcome must be success or failure")
+		}
+		params.Outcome = sql.NullString{String: v, Valid: true}
+	}
+	if v := query.Get("actor_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return params, fmt.Errorf("invalid actor_id")
+		}
+		params.ActorID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+	if v := query.Get("target_id"); v != "" {
+		params.TargetID = sql.NullString{String: v, Valid: true}
+	}
+	for name, field := range map[string]*sql.NullTime{"since": &params.Since, "until": &params.Until} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return params, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*field = sql.NullTime{Time: t.UTC(), Valid: true}
+		}
+	}
+	if v := query.Get("cursor"); v != "" {
+		t, id, err := decodeAuditCursor(v)
+		if err != nil {
+			return params, fmt.Errorf("invalid cursor")
+		}
+		params.BeforeCreatedAt = sql.NullTime{Time: t, Valid: true}
+		params.BeforeID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+	return params, nil
+}
+
+func (cfg *apiConfig) listAuditHandler(w http.ResponseWriter, req *http.Request) {	//Pages through audit events newest first, following next_cursor for older ones
+	params, err := auditFilters(req)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	params.Limit = 100
+	if v := req.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			respondWithError(w, 400, "Limit must be between 1 and 1000")
+			return
+		}
+		params.Limit = int32(n)
+	}
+
+	events, err := cfg.db.ListAuditEvents(req.Context(), params)
+	if err != nil {
+		fmt.Printf("Error listing audit events: %s\n", err)
+		respondWithError(w, 500, "Error listing audit events")
+		return
+	}
+	res := struct {
+		Events []AuditEvent `json:"events"`
+		NextCursor string `json:"next_cursor,omitempty"`	//Empty on the last page
+	}{
+		Events: make([]AuditEvent, 0, len(events)),
+	}
+	for _, e := range events {
+		res.Events = append(res.Events, toAuditEvent(e))
+	}
+	if len(events) == int(params.Limit) {
+		res.NextCursor = encodeAuditCursor(events[len(events)-1])
+	}
+	respondWithJSON(w, 200, res)
+}
+
+func (cfg *apiConfig) exportAuditHandler(w http.ResponseWriter, req *http.Request) {	//Streams every matching audit event as newline delimited JSON
+	params, err := auditFilters(req)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	params.Limit = 1000
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-events.ndjson"`)
+	encoder := json.NewEncoder(w)	//Writes one event per line
+	wroteHeader := false
+	for {
+		events, err := cfg.db.ListAuditEvents(req.Context(), params)
+		if err != nil {
+			fmt.Printf("Error exporting audit events: %s\n", err)
+			if !wroteHeader {
+				respondWithError(w, 500, "Error exporting audit events")
+			}
+			return	//Mid-stream the truncated body is all we can do
+		}
+		if !wroteHeader {
+			w.WriteHeader(200)
+			wroteHeader = true
+		}
+		for _, e := range events {
+			if err := encoder.Encode(toAuditEvent(e)); err != nil {
+				return
+			}
+		}
+		if len(events) < int(params.Limit) {
+			return
+		}
+		last := events[len(events)-1]
+		params.BeforeCreatedAt = sql.NullTime{Time: last.CreatedAt, Valid: true}
+		params.BeforeID = uuid.NullUUID{UUID: last.ID, Valid: true}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+func TestAuditCursorRoundTrip(t *testing.T) {
+	event := database.AuditEvent{
+		ID: uuid.New(),
+		CreatedAt: time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC),
+	}
+	createdAt, id, err := decodeAuditCursor(encodeAuditCursor(event))
+	if err != nil {
+		t.Fatalf("decoding cursor: %s", err)
+	}
+	if !createdAt.Equal(event.CreatedAt) || id != event.ID {
+		t.Errorf("got %s %s, wanted %s %s", createdAt, id, event.CreatedAt, event.ID)
+	}
+
+	for _, cursor := range []string{"", "!!!", "bm90LWEtY3Vyc29y"} {
+		if _, _, err := decodeAuditCursor(cursor); err == nil {
+			t.Errorf("cursor %q accepted", cursor)
+		}
+	}
+}
+
+func TestAuditFilters(t *testing.T) {
+	actor := uuid.New()
+	req := httptest.NewRequest("GET", "/admin/audit?action=login&outcome=failure&actor_id="+actor.String()+"&since=2024-01-01T00:00:00Z", nil)
+	params, err := auditFilters(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if params.Action != (sql.NullString{String: "login", Valid: true}) || params.Outcome.String != auditFailure {
+		t.Errorf("action and outcome not set: %+v", params)
+	}
+	if params.ActorID != (uuid.NullUUID{UUID: actor, Valid: true}) {
+		t.Errorf("actor_id not set: %+v", params.ActorID)
+	}
+	if !params.Since.Valid || params.Until.Valid || params.BeforeID.Valid {
+		t.Errorf("wrong time filters: %+v", params)
+	}
+
+	for _, query := range []string{"outcome=maybe", "actor_id=nope", "since=yesterday", "cursor=%21"} {
+		if _, err := auditFilters(httptest.NewRequest("GET", "/admin/audit?"+query, nil)); err == nil {
+			t.Errorf("%s accepted", query)
+		}
+	}
+}
+
+func TestToAuditEvent(t *testing.T) {
+	event := toAuditEvent(database.AuditEvent{Details: "not json"})
+	if event.ActorID != nil {
+		t.Error("missing actor should be null")
+	}
+	if string(event.Details) != "{}" {
+		t.Errorf("invalid details not replaced: %s", event.Details)
+	}
+}
@@ -1,36 +1,89 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"sort"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jms-guy/chirpy/internal/auth"
 	"github.com/jms-guy/chirpy/internal/database"
+	"github.com/jms-guy/chirpy/internal/mail"
+	"github.com/jms-guy/chirpy/internal/oidc"
+	"github.com/jms-guy/chirpy/internal/revocation"
+	"github.com/jms-guy/chirpy/internal/storage"
+	"github.com/jms-guy/chirpy/internal/throttle"
+	"github.com/jms-guy/chirpy/internal/webhook"
 )
 
 type apiConfig struct {
 	db *database.Queries
+	conn *sql.DB	//The connection behind db, for the few handlers that need a transaction
 	platform string
-	tokenSecret string
-	polkaKey string
+	keys *auth.KeyRing
+	webhooks *webhook.Verifier	//Nil when no Polka webhook secrets are configured
+	webhookWake chan struct{}	//Nudges the webhook worker when an event is queued
+	mailer mail.Mailer
+	baseURL string	//Public URL of the server, used to build links sent by email
+	requireVerifiedEmail bool	//Blocks posting chirps until the author's email is verified
+	accountLimiter *throttle.Limiter	//Failed logins per email address
+	ipLimiter *throttle.Limiter	//Failed logins per client IP
+	mailLimiter *throttle.Limiter	//Emails sent on request of an anonymous caller, per address
+	mailIPLimiter *throttle.Limiter	//The same, per client IP
+	oidc *oidc.Provider	//Nil when single sign-on isn't configured
+	oidcStates *oidc.StateStore
+	passwordPolicy auth.PasswordPolicy
+	revocations *revocation.Checker	//Deny-list and token versions for access tokens
+	secureCookies bool	//Marks session cookies Secure, off only for plain HTTP development servers
+	deletionGrace time.Duration	//How long a deleted account can still be restored by logging in
+	avatars storage.Store
 	fileserverHits atomic.Int32
 }
 
-type User struct {
-		ID uuid.UUID `json:"id"`
-		CreatedAt time.Time `json:"created_at"`
-		UpdatedAt time.Time `json:"updated_at"`
-		Email string `json:"email"`
-		Token string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-		IsChirpyRed bool `json:"is_chirpy_red"`
-	}
+type Profile struct {	//Fields a user fills in about themselves, shown to everyone
+	Handle string `json:"handle"`	//Empty until the user picks one
+	DisplayName string `json:"display_name"`
+	Bio string `json:"bio"`
+	Location string `json:"location"`
+	Website string `json:"website"`
+	Avatar map[string]string `json:"avatar"`	//URL of each thumbnail size by name, null without an avatar
+}
+
+type PublicUser struct {	//What anyone may see about a user
+	ID uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Profile
+	ChirpyRed bool `json:"is_chirpy_red"`
+}
+
+type PrivateUser struct {	//What a user sees about themselves
+	ID uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Email string `json:"email"`
+	EmailVerified bool `json:"email_verified"`
+	Role string `json:"role"`
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+	Subscription *Subscription `json:"subscription"`	//Null when the user has never subscribed
+	Profile
+}
+
+type LoginResponse struct {	//The user plus their new tokens, in one flat object
+	PrivateUser
+	Token string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	CSRFToken string `json:"csrf_token,omitempty"`	//Only set for cookie sessions, must be sent back in the X-CSRF-Token header
+}
 
 type Chirp struct {
 	ID uuid.UUID `json:"id"`
@@ -40,68 +93,76 @@ type Chirp struct {
 	UserID uuid.UUID `json:"user_id"`
 }
 
+func (cfg *apiConfig) inTx(ctx context.Context, fn func(q *database.Queries) error) error {	//Runs fn in a transaction, committing only if it returns nil
+	tx, err := cfg.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()	//No-op once committed
+	if err := fn(cfg.db.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 ///// Config handle methods
 
-func (cfg *apiConfig) webhookHandler(w http.ResponseWriter, req *http.Request) {
+func (cfg *apiConfig) webhookHandler(w http.ResponseWriter, req *http.Request) {	//Receives Chirpy Red subscription events from Polka and queues them for the webhook worker
 	type httpRequest struct {
+		ID string `json:"id"`
 		Event string `json:"event"`
-		Data struct {
-			UserID string `json:"user_id"`
-		} `json:"data"`
 	}
 
-	apiKey, keyErr := auth.GetAPIKey(req.Header)
-	if keyErr != nil {
-		fmt.Printf("Error getting ApiKey from header: %s", keyErr)
-		w.WriteHeader(401)
+	if cfg.webhooks == nil {
+		respondWithError(w, 404, "Webhooks are not configured")
 		return
 	}
-	if apiKey != cfg.polkaKey {
-		fmt.Printf("Bad ApiKey")
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, 1<<20))	//The signature covers the raw bytes, so they are read before decoding
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	timestamp := req.Header.Get(webhook.TimestampHeader)
+	if _, err := cfg.webhooks.Verify(body, timestamp, req.Header.Get(webhook.SignatureHeader)); err != nil {
+		fmt.Printf("Rejected Polka webhook: %s\n", err)
 		w.WriteHeader(401)
 		return
 	}
 
 	request := httpRequest{}
-	reqErr := json.NewDecoder(req.Body).Decode(&request)	//Gets request data
-	if reqErr != nil {
+	reqErr := json.Unmarshal(body, &request)	//Gets request data
+	if reqErr != nil || request.Event == "" {
 		fmt.Printf("Error decoding request body: %s", reqErr)
 		w.WriteHeader(400)
 		return
 	}
-
-	if request.Event != "user.upgraded" {
-		w.WriteHeader(204)
-		return
+	eventID := request.ID
+	if eventID == "" {	//Without an id, a replay of the same signed delivery is still caught
+		eventID = webhook.Nonce(timestamp, body)
 	}
 
-	id, err := uuid.Parse(request.Data.UserID)
-	if err != nil {
-		fmt.Printf("Failure to parse user_id in request: %s", err)
-		w.WriteHeader(400)
+	queued, err := cfg.db.EnqueueWebhookEvent(req.Context(), database.EnqueueWebhookEventParams{
+		ID: uuid.New(),
+		EventID: eventID,
+		Event: request.Event,
+		Payload: string(body),
+	})
+	if err != nil {	//Polka retries on 5xx, so the event isn't lost
+		fmt.Printf("Error queueing webhook event %s: %s\n", eventID, err)
+		w.WriteHeader(503)
 		return
 	}
-
-	if updateErr := cfg.db.UpdateChirpyRed(req.Context(), id); updateErr != nil {
-		fmt.Printf("Failure to update is_chirpy_red status for user %s: %s", id, updateErr)
-		w.WriteHeader(404)
-		return
+	if queued == 0 {
+		fmt.Printf("Ignoring duplicate Polka event %s\n", eventID)
+	} else {
+		cfg.wakeWebhookWorker()
 	}
 	w.WriteHeader(204)
 }
 
 func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, req *http.Request) {
-	token, err := auth.GetBearerToken(req.Header)
-	if err != nil {
-		respondWithError(w, 401, "Bad token")
-		return
-	}
-
-	userId, valErr := auth.ValidateJWT(token, cfg.tokenSecret)
-	if valErr != nil {
-		respondWithError(w, 401, "Bad token")
-		return
-	}
+	userId := principalFrom(req.Context()).UserID
 
 	chirpId := req.PathValue("chirpId")	//Gets chirpid string from path
 
@@ -120,6 +181,7 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, req *http.Reques
 	}
 
 	if chirp.UserID != userId {
+		cfg.audit(req, auditEntry{Action: auditChirpDelete, Outcome: auditFailure, TargetType: "chirp", TargetID: id.String(), Details: map[string]any{"reason": "not_owner"}})
 		w.WriteHeader(403)
 		return
 	}
@@ -129,17 +191,22 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, req *http.Reques
 		respondWithError(w, 500, "Error deleting chirp")
 		return
 	}
+	cfg.audit(req, auditEntry{Action: auditChirpDelete, TargetType: "chirp", TargetID: id.String()})
 	w.WriteHeader(204)
 }
 
-func (cfg *apiConfig) revokeHandler(w http.ResponseWriter, req *http.Request) {
-	tokenString, err := auth.GetBearerToken(req.Header)
+func (cfg *apiConfig) revokeHandler(w http.ResponseWriter, req *http.Request) {	//Logs out by revoking a refresh token, from the Authorization header or the session cookie
+	tokenString, fromCookie, err := sessionToken(req, refreshCookie)
+	if errors.Is(err, errCSRF) {
+		respondWithAuthError(w, err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, 400, "No token found")
 		return
 	}
 
-	token, err := cfg.db.GetToken(req.Context(), tokenString)
+	token, err := cfg.db.GetToken(req.Context(), auth.HashToken(tokenString))
 	if err != nil {
 		respondWithError(w, 401, "Token does not exist or is expired")
 		return
@@ -147,33 +214,48 @@ func (cfg *apiConfig) revokeHandler(w http.ResponseWriter, req *http.Request) {
 
 	revokeParams := database.RevokeTokenParams{
 			RevokedAt: sql.NullTime{Time: time.Now(), Valid: true},
-			Token: token.Token,
+			TokenHash: token.TokenHash,
 		}
 
 	if err := cfg.db.RevokeToken(req.Context(), revokeParams); err != nil {
 			respondWithError(w, 500, "Error revoking token")
 			return
 		}
+	cfg.denySession(req.Context(), token.FamilyID)	//Access tokens from this login stop working now rather than when they expire
+	cfg.audit(req, auditEntry{Action: auditTokenRevoke, ActorID: token.UserID, TargetType: "session", TargetID: token.FamilyID.String()})
+	if fromCookie {
+		cfg.clearSessionCookies(w)
+	}
 	w.WriteHeader(204)
 }
 
-func (cfg *apiConfig) refreshHandler(w http.ResponseWriter, req *http.Request) {
-	tokenString, err := auth.GetBearerToken(req.Header)
+func (cfg *apiConfig) refreshHandler(w http.ResponseWriter, req *http.Request) {	//Exchanges a refresh token for a new access token and a new refresh token, revoking the old one
+	tokenString, fromCookie, err := sessionToken(req, refreshCookie)
+	if errors.Is(err, errCSRF) {
+		respondWithAuthError(w, err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, 400, "No token found")
 		return
 	}
 
-	token, err := cfg.db.GetToken(req.Context(), tokenString)
+	token, err := cfg.db.LookupToken(req.Context(), auth.HashToken(tokenString))
 	if err != nil {
 		respondWithError(w, 401, "Token does not exist or is expired")
 		return
 	}
 
+	if token.RevokedAt.Valid {	//A revoked token being presented again means it was stolen, so the whole family is revoked
+		cfg.revokeFamily(req, token, "reuse")
+		respondWithError(w, 401, "Token has been revoked")
+		return
+	}
+
 	if token.ExpiresAt.Before(time.Now()) {
 		revokeParams := database.RevokeTokenParams{
 			RevokedAt: sql.NullTime{Time: time.Now(), Valid: true},
-			Token: token.Token,
+			TokenHash: token.TokenHash,
 		}
 		if err := cfg.db.RevokeToken(req.Context(), revokeParams); err != nil {
 			respondWithError(w, 500, "Error revoking token")
@@ -183,31 +265,105 @@ func (cfg *apiConfig) refreshHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	userId := token.UserID
-	accessToken, err := auth.MakeJWT(userId, cfg.tokenSecret)
+	user, err := cfg.db.GetUserFromID(req.Context(), token.UserID)	//Fresh lookup so role changes apply on the next refresh
+	if err != nil {
+		respondWithError(w, 500, "Error finding user")
+		return
+	}
+	if user.BannedAt.Valid {	//Checked before rotating so a banned user never gets a new token row
+		cfg.audit(req, auditEntry{Action: auditTokenRefresh, Outcome: auditFailure, ActorID: user.ID, TargetType: "session", TargetID: token.FamilyID.String(), Details: map[string]any{"reason": "banned"}})
+		respondWithError(w, 401, "Token has been revoked")
+		return
+	}
+
+	refreshString, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, 500, "Error creating refresh token")
+		return
+	}
+	tokenParams := database.CreateTokenParams{
+		TokenHash: auth.HashToken(refreshString),	//Only the digest is stored, the raw token goes to the client
+		UserID: token.UserID,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+		FamilyID: token.FamilyID,	//New token joins the family of the one it replaces
+		SessionCreatedAt: token.SessionCreatedAt,
+		UserAgent: req.UserAgent(),
+		IpAddress: clientIP(req),
+		Name: token.Name,
+	}
+	err = cfg.inTx(req.Context(), func(q *database.Queries) error {	//The old token is only spent if its replacement is saved
+		revoked, err := q.RevokeActiveToken(req.Context(), token.TokenHash)
+		if err != nil {
+			return err
+		}
+		if revoked == 0 {
+			return errTokenRotated
+		}
+		_, err = q.CreateToken(req.Context(), tokenParams)
+		return err
+	})
+	if errors.Is(err, errTokenRotated) {	//Another request rotated this token first, treat it the same as reuse
+		cfg.revokeFamily(req, token, "concurrent_rotation")
+		respondWithError(w, 401, "Token has been revoked")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Error rotating refresh token")
+		return
+	}
+	accessToken, err := auth.MakeJWT(auth.AccessToken{
+		UserID: user.ID,
+		Role: user.Role,
+		SessionID: token.FamilyID,
+		Version: user.TokenVersion,
+		AuthTime: token.SessionCreatedAt,	//The login that started the session
+	}, cfg.keys)
 	if err != nil {
 		respondWithError(w, 500, "Error creating access token")
 		return
 	}
+	cfg.audit(req, auditEntry{Action: auditTokenRefresh, ActorID: user.ID, TargetType: "session", TargetID: token.FamilyID.String()})
+	if fromCookie {	//Browser sessions get the new pair as cookies, the CSRF token stays the same
+		cfg.setSessionCookies(w, accessToken, refreshString, "")
+		w.WriteHeader(204)
+		return
+	}
 	respondWithJSON(w, 200, struct {
 		Token string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}{
 		Token: accessToken,
+		RefreshToken: refreshString,
 	})	
 }
 
-func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, req *http.Request) {
-	type httpRequest struct {
-		Password string `json:"password"`
-		Email string `json:"email"`
+var errTokenRotated = errors.New("refresh token already rotated")
+
+func (cfg *apiConfig) revokeFamily(req *http.Request, token database.RefreshToken, reason string) {	//Revokes every refresh token descended from the same login
+	fmt.Printf("Refresh token reuse detected for user %s, revoking token family %s\n", token.UserID, token.FamilyID)
+	cfg.audit(req, auditEntry{
+		Action: auditTokenRefresh,
+		Outcome: auditFailure,
+		ActorID: token.UserID,
+		TargetType: "session",
+		TargetID: token.FamilyID.String(),
+		Details: map[string]any{"reason": reason},
+	})
+	if err := cfg.db.RevokeTokenFamily(req.Context(), token.FamilyID); err != nil {
+		fmt.Printf("Error revoking token family %s: %s\n", token.FamilyID, err)
 	}
+	cfg.denySession(req.Context(), token.FamilyID)
+}
 
-	token, err := auth.GetBearerToken(req.Header)
-	if err != nil {
-		respondWithError(w, 401, "Bad token")
-		return
+func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, req *http.Request) {	//Changes the caller's email address or password. Profile fields are changed through updateProfileHandler instead
+	type httpRequest struct {
+		CurrentPassword string `json:"current_password"`	//Required, so a stolen access token alone can't take over the account
+		Password string `json:"password"`	//New password, unchanged when empty
+		Email string `json:"email"`	//Unchanged when empty
 	}
 
+	id := principalFrom(req.Context()).UserID
+
 	request := httpRequest{}
 	reqErr := json.NewDecoder(req.Body).Decode(&request)	//Gets request data
 	if reqErr != nil {
@@ -216,25 +372,36 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	id, valErr := auth.ValidateJWT(token, cfg.tokenSecret)
-	if valErr != nil {
-		respondWithError(w, 401, "Bad token")
-		return
-	}
-
 	user, findErr := cfg.db.GetUserFromID(req.Context(), id)
 	if findErr != nil {
 		respondWithError(w, 500, "Error finding user")
 		return
 	}
 
-
-	hash, err := auth.HashPassword(request.Password)	//Hashes password
-	if err != nil {
-		fmt.Printf("Error hashing password: %s", err)
-		respondWithError(w, 400, "Invalid password string")
+	action := auditEmailChange
+	if request.Password != "" {
+		action = auditPasswordChange
+	}
+	if !cfg.confirmPassword(w, req, action, user, request.CurrentPassword) {
 		return
 	}
+	if request.Email == "" {
+		request.Email = user.Email
+	}
+
+	hash := user.HashedPassword
+	passwordChanged := request.Password != "" && request.Password != request.CurrentPassword
+	if passwordChanged {
+		if !cfg.checkPasswordPolicy(w, request.Password, request.Email) {
+			return
+		}
+		var hashErr error
+		if hash, hashErr = auth.HashPassword(request.Password); hashErr != nil {	//Hashes password
+			fmt.Printf("Error hashing password: %s", hashErr)
+			respondWithError(w, 400, "Invalid password string")
+			return
+		}
+	}
 
 	updateInfo := database.UpdateUserInfoParams{
 		Email: request.Email,
@@ -246,19 +413,36 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, req *http.Request
 		respondWithError(w, 500, "Error updating database")
 		return
 	}
-	resUser := User{
-		ID: user.ID,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: time.Now(),
-		Email: request.Email,
+	if passwordChanged {	//Outstanding access tokens, including the caller's, stop working and have to be refreshed
+		if err := cfg.revokeAccessTokens(req.Context(), user.ID); err != nil {
+			fmt.Printf("Error revoking access tokens for user %s: %s\n", user.ID, err)
+		}
+		cfg.audit(req, auditEntry{Action: auditPasswordChange, TargetType: "user", TargetID: user.ID.String()})
 	}
-	respondWithJSON(w, 200, resUser)
+
+	if request.Email != user.Email {	//A changed address has to be verified again
+		cfg.audit(req, auditEntry{
+			Action: auditEmailChange,
+			TargetType: "user",
+			TargetID: user.ID.String(),
+			Details: map[string]any{"old_email": user.Email, "new_email": request.Email},
+		})
+		user.EmailVerifiedAt = sql.NullTime{}
+		user.Email = request.Email
+		if err := cfg.sendVerificationEmail(req.Context(), user, verifyEmailChange); err != nil {
+			fmt.Printf("Error sending verification email to user %s: %s\n", user.ID, err)
+		}
+	}
+
+	user.UpdatedAt = time.Now()
+	respondWithJSON(w, 200, cfg.privateUser(req.Context(), user))
 }
 
 func (cfg *apiConfig) loginHandler(w http.ResponseWriter, req *http.Request) {	//Returns a user struct from the database based on a given email and password string
 	type httpRequest struct {
 		Password string `json:"password"`
 		Email string `json:"email"`
+		UseCookies bool `json:"use_cookies"`	//Browser clients get their tokens as HttpOnly cookies instead of in the body
 	}
 
 	request := httpRequest{}
@@ -269,49 +453,194 @@ func (cfg *apiConfig) loginHandler(w http.ResponseWriter, req *http.Request) {	/
 		return
 	}
 
+	accountKey := strings.ToLower(request.Email)
+	if !cfg.allowLogin(w, req, accountKey) {
+		return
+	}
+
+	start := time.Now()
 	newUser, err := cfg.db.GetUserFromEmail(req.Context(), request.Email)	//Gets user struct
 	if err != nil {
-		fmt.Printf("Error getting user from db: %s", err)
-		respondWithError(w, 401, "Incorrect email")
+		auth.CheckDummyPassword(request.Password)	//Keeps response time the same whether or not the email exists
+		auth.PadPasswordCheck(start)
+		cfg.failLogin(req, auditLogin, accountKey, uuid.Nil, "unknown_email")
+		respondWithError(w, 401, "Incorrect email or password")
 		return
 	}
 
 	if err := auth.CheckPasswordHash(newUser.HashedPassword, request.Password); err != nil {	//Authenticates user from password string, against hash in user struct
-		respondWithError(w, 401, "Incorrect password")
+		auth.PadPasswordCheck(start)	//Accounts still on bcrypt would otherwise answer slower than unknown emails
+		cfg.failLogin(req, auditLogin, accountKey, newUser.ID, "bad_password")
+		respondWithError(w, 401, "Incorrect email or password")
+		return
+	}
+	if auth.NeedsRehash(newUser.HashedPassword) {	//Upgrades bcrypt or outdated Argon2id hashes while the plain password is at hand
+		cfg.rehashPassword(req, newUser, request.Password)
+	}
+
+	if newUser.TotpEnabledAt.Valid {	//Enrolled users get a challenge token to exchange along with a TOTP code instead
+		cfg.sendTOTPChallenge(w, newUser)
 		return
 	}
 
-	token, err := auth.MakeJWT(newUser.ID, cfg.tokenSecret)	//Creates access token for user
+	cfg.accountLimiter.Reset(accountKey)	//The IP count is left alone so one valid account can't be used to reset it
+	cfg.completeLogin(w, req, auditLogin, newUser, request.UseCookies)
+}
+
+func (cfg *apiConfig) sendTOTPChallenge(w http.ResponseWriter, user database.User) {	//Responds with a challenge token for the second login step at POST /api/login/2fa
+	challenge, err := auth.MakeChallengeJWT(user.ID, cfg.keys)
 	if err != nil {
-		respondWithError(w, 500, "Error creating access token")
+		respondWithError(w, 500, "Error creating challenge token")
 		return
 	}
+	respondWithJSON(w, 202, struct {
+		TwoFactorRequired bool `json:"two_factor_required"`
+		ChallengeToken string `json:"challenge_token"`
+	}{
+		TwoFactorRequired: true,
+		ChallengeToken: challenge,
+	})
+}
+
+func (cfg *apiConfig) rehashPassword(req *http.Request, user database.User, password string) {	//Failures are only logged, the old hash still works
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		fmt.Printf("Error rehashing password for user %s: %s\n", user.ID, err)
+		return
+	}
+	if err := cfg.db.UpdatePassword(req.Context(), database.UpdatePasswordParams{
+		HashedPassword: hash,
+		ID: user.ID,
+	}); err != nil {
+		fmt.Printf("Error saving rehashed password for user %s: %s\n", user.ID, err)
+	}
+}
+
+func (cfg *apiConfig) allowLogin(w http.ResponseWriter, req *http.Request, accountKey string) bool {	//Rejects login attempts from accounts or IPs that have failed too often recently
+	wait, ok := cfg.ipLimiter.Allow(clientIP(req))
+	if accountWait, accountOk := cfg.accountLimiter.Allow(accountKey); !accountOk {
+		ok = false
+		wait = max(wait, accountWait)
+	}
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondWithError(w, 429, "Too many failed login attempts, try again later")
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) confirmPassword(w http.ResponseWriter, req *http.Request, action string, user database.User, password string) bool {	//Checks a signed in user's password with the same limits and padding as loginHandler, so a stolen access token can't be used to guess it. Responds itself when the check fails
+	accountKey := strings.ToLower(user.Email)
+	if !cfg.allowLogin(w, req, accountKey) {
+		return false
+	}
+	start := time.Now()
+	if !auth.HasPassword(user.HashedPassword) || auth.CheckPasswordHash(user.HashedPassword, password) != nil {
+		auth.PadPasswordCheck(start)
+		cfg.failLogin(req, action, accountKey, user.ID, "bad_password")
+		respondWithError(w, 401, "Incorrect password")
+		return false
+	}
+	cfg.accountLimiter.Reset(accountKey)
+	return true
+}
+
+func (cfg *apiConfig) allowMail(w http.ResponseWriter, req *http.Request, email string) bool {	//Counts a request for an emailed link against the address and the caller's IP, rejecting it once either has asked too often
+	key := strings.ToLower(email)
+	wait, ok := cfg.mailIPLimiter.Allow(clientIP(req))
+	if accountWait, accountOk := cfg.mailLimiter.Allow(key); !accountOk {
+		ok = false
+		wait = max(wait, accountWait)
+	}
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondWithError(w, 429, "Too many requests, try again later")
+		return false
+	}
+	cfg.mailLimiter.Fail(key)	//Every send counts, not just failed ones
+	cfg.mailIPLimiter.Fail(clientIP(req))
+	return true
+}
+
+func (cfg *apiConfig) failLogin(req *http.Request, action, accountKey string, userID uuid.UUID, reason string) {	//userID is uuid.Nil when no account matched
+	fmt.Printf("Failed login for %q from %s\n", accountKey, clientIP(req))
+	cfg.audit(req, auditEntry{
+		Action: action,
+		Outcome: auditFailure,
+		ActorID: userID,
+		TargetType: "user",
+		TargetID: accountKey,
+		Details: map[string]any{"reason": reason},
+	})
+	cfg.accountLimiter.Fail(accountKey)
+	cfg.ipLimiter.Fail(clientIP(req))
+}
+
+func (cfg *apiConfig) completeLogin(w http.ResponseWriter, req *http.Request, action string, newUser database.User, useCookies bool) {	//Issues access and refresh tokens for an authenticated user and responds with them, or sets them as cookies. action names the login method for the audit log
+	if newUser.BannedAt.Valid {
+		cfg.audit(req, auditEntry{Action: action, Outcome: auditFailure, ActorID: newUser.ID, TargetType: "user", TargetID: newUser.ID.String(), Details: map[string]any{"reason": "banned"}})
+		respondWithError(w, 403, "Account is suspended")
+		return
+	}
+	if newUser.DeletedAt.Valid {
+		cfg.restoreAccount(req, newUser.ID)
+	}
 
 	refreshString, err := auth.MakeRefreshToken()	//Creates a refresh token for user
 	if err != nil {
 		respondWithError(w,  500, "Error creating refresh token")
+		return
 	}
 	tokenParams := database.CreateTokenParams{
-		Token: refreshString,
+		TokenHash: auth.HashToken(refreshString),	//Only the digest is stored, the raw token goes to the client
 		UserID: newUser.ID,
-		ExpiresAt: time.Now().Add(1440 * time.Hour),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+		FamilyID: uuid.New(),	//Each login starts a new token family
+		SessionCreatedAt: time.Now(),
+		UserAgent: req.UserAgent(),
+		IpAddress: clientIP(req),
 	}
 
-	refreshToken, err := cfg.db.CreateToken(req.Context(), tokenParams)
-	if err != nil {
+	if _, err := cfg.db.CreateToken(req.Context(), tokenParams); err != nil {
 		respondWithError(w, 500, "Error creating refresh token")
 		return
 	}
 
-	user := User{	//Structures user data in json payload
-		ID: newUser.ID,
-		CreatedAt: newUser.CreatedAt,
-		UpdatedAt: newUser.UpdatedAt,
-		Email: newUser.Email,
+	token, err := auth.MakeJWT(auth.AccessToken{	//Creates access token for user
+		UserID: newUser.ID,
+		Role: newUser.Role,
+		SessionID: tokenParams.FamilyID,
+		Version: newUser.TokenVersion,
+		AuthTime: tokenParams.SessionCreatedAt,
+	}, cfg.keys)
+	if err != nil {
+		respondWithError(w, 500, "Error creating access token")
+		return
+	}
+
+	user := LoginResponse{	//Structures user data in json payload
+		PrivateUser: cfg.privateUser(req.Context(), newUser),
 		Token: token,
-		RefreshToken: refreshToken.Token,
-		IsChirpyRed: newUser.IsChirpyRed,
+		RefreshToken: refreshString,
 	}
+	if useCookies {
+		csrfToken, err := newCSRFToken()
+		if err != nil {
+			respondWithError(w, 500, "Error creating session")
+			return
+		}
+		cfg.setSessionCookies(w, token, refreshString, csrfToken)
+		user.Token, user.RefreshToken = "", ""	//Kept out of the body so scripts never see them
+		user.CSRFToken = csrfToken
+	}
+	cfg.audit(req, auditEntry{
+		Action: action,
+		ActorID: newUser.ID,
+		TargetType: "session",
+		TargetID: tokenParams.FamilyID.String(),
+		Details: map[string]any{"cookies": useCookies},
+	})
 	respondWithJSON(w, 200, user)
 }
 
@@ -395,15 +724,18 @@ func (cfg *apiConfig) chirpsHandler(w http.ResponseWriter, req *http.Request) {
 		Body string `json:"body"`
 	}
 
-	token, tokenErr := auth.GetBearerToken(req.Header)	//Gets authorization token from request
-	if tokenErr != nil {
-		respondWithError(w, 401, "Missing authorization header")
-		return
-	}
-	id, valErr := auth.ValidateJWT(token, cfg.tokenSecret)	//Validates authorization
-	if valErr != nil {
-		respondWithError(w, 401, "Unauthorized")
-		return
+	id := principalFrom(req.Context()).UserID
+
+	if cfg.requireVerifiedEmail {
+		user, err := cfg.db.GetUserFromID(req.Context(), id)
+		if err != nil {
+			respondWithError(w, 500, "Error finding user")
+			return
+		}
+		if !user.EmailVerifiedAt.Valid {
+			respondWithError(w, 403, "Email address must be verified before posting")
+			return
+		}
 	}
 
 	request := chirpRequest{}
@@ -451,6 +783,9 @@ func (cfg *apiConfig) usersHandler(w http.ResponseWriter, req *http.Request) {	/
 		return
 	}
 
+	if !cfg.checkPasswordPolicy(w, request.Password, request.Email) {
+		return
+	}
 	hash, err := auth.HashPassword(request.Password)	//Hashes password
 	if err != nil {
 		fmt.Printf("Error hashing password: %s", err)
@@ -471,14 +806,17 @@ func (cfg *apiConfig) usersHandler(w http.ResponseWriter, req *http.Request) {	/
 		return
 	}
 
-	user := User{	//Map new user to config user struct
-		ID: newUser.ID,
-		CreatedAt: newUser.CreatedAt,
-		UpdatedAt: newUser.UpdatedAt,
-		Email: newUser.Email,
-		IsChirpyRed: newUser.IsChirpyRed,
+	cfg.audit(req, auditEntry{Action: auditUserCreate, ActorID: newUser.ID, TargetType: "user", TargetID: newUser.ID.String()})
+	if err := cfg.sendVerificationEmail(req.Context(), newUser, verifySignup); err != nil {	//The account still exists, the user can ask for the link again
+		fmt.Printf("Error sending verification email to user %s: %s\n", newUser.ID, err)
 	}
-	respondWithJSON(w, 201, user)	//Send response data
+
+	respondWithJSON(w, 201, cfg.privateUser(req.Context(), newUser))	//Send response data
+}
+
+func (cfg *apiConfig) jwksHandler(w http.ResponseWriter, req *http.Request) {	//Publishes the public signing keys so other services can verify access tokens
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, 200, cfg.keys.JWKS())
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {	//Wraps server handles in a function that increases server visit count
@@ -500,6 +838,7 @@ func (cfg *apiConfig) hitsHandler(w http.ResponseWriter, req *http.Request) {
 
 func (cfg *apiConfig) resetHandler(w http.ResponseWriter, req *http.Request) {	
 	if cfg.platform != "dev" {
+		cfg.audit(req, auditEntry{Action: auditAdminReset, Outcome: auditFailure, Details: map[string]any{"reason": "not_dev_platform"}})
 		respondWithError(w, 403, "User does not have access to this page")
 		return
 	}
@@ -509,5 +848,51 @@ func (cfg *apiConfig) resetHandler(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, 500, "Error clearing database")
 		return
 	}
+	cfg.audit(req, auditEntry{Action: auditAdminReset})	//Kept, as the audit log isn't tied to the users table
 	respondWithJSON(w, 200, map[string]string{"status": "Users table cleared successfully"})
-}
\ No newline at end of file
+}
+
+func (cfg *apiConfig) setRoleHandler(w http.ResponseWriter, req *http.Request) {	//Changes a user's role. Their access tokens are voided, so it takes effect as soon as they refresh
+	type httpRequest struct {
+		Role string `json:"role"`
+	}
+
+	adminId := principalFrom(req.Context()).UserID
+
+	id, err := uuid.Parse(req.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	if id == adminId {	//Stops the last admin from locking everyone out
+		respondWithError(w, 400, "Admins cannot change their own role")
+		return
+	}
+
+	request := httpRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, 400, "Invalid JSON payload")
+		return
+	}
+	if !auth.ValidRole(request.Role) {
+		respondWithError(w, 400, "Unknown role")
+		return
+	}
+
+	updated, err := cfg.db.SetUserRole(req.Context(), database.SetUserRoleParams{	//Also bumps the token version, tokens carrying the old role stop working
+		Role: request.Role,
+		ID: id,
+	})
+	if err != nil {
+		respondWithError(w, 500, "Error updating role")
+		return
+	}
+	if updated == 0 {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	cfg.revocations.Forget(id)
+	fmt.Printf("User %s set role of user %s to %s\n", adminId, id, request.Role)
+	cfg.audit(req, auditEntry{Action: auditAdminRoleChange, TargetType: "user", TargetID: id.String(), Details: map[string]any{"role": request.Role}})
+	w.WriteHeader(204)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/oidc"
+)
+
+const (
+	accessCookie = "chirpy_access"
+	refreshCookie = "chirpy_refresh"
+	csrfCookie = "chirpy_csrf"	//Readable by the web client, which echoes it back in csrfHeader
+	csrfHeader = "X-CSRF-Token"
+
+	accessTokenTTL = 1 * time.Hour	//Matches the expiry set by auth.MakeJWT
+	refreshTokenTTL = 1440 * time.Hour
+)
+
+var errCSRF = errors.New("missing or invalid csrf token")
+
+func (cfg *apiConfig) setSessionCookies(w http.ResponseWriter, accessToken, refreshToken, csrfToken string) {	//Hands the browser its tokens as cookies scripts can't read. csrfToken is left alone when empty
+	http.SetCookie(w, &http.Cookie{
+		Name: accessCookie,
+		Value: accessToken,
+		Path: "/",
+		MaxAge: int(accessTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure: cfg.secureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name: refreshCookie,
+		Value: refreshToken,
+		Path: "/api",	//Only the refresh and revoke routes read it, but it must cover both
+		MaxAge: int(refreshTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure: cfg.secureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+	if csrfToken != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name: csrfCookie,
+			Value: csrfToken,
+			Path: "/",
+			MaxAge: int(refreshTokenTTL.Seconds()),
+			Secure: cfg.secureCookies,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+func (cfg *apiConfig) clearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: accessCookie, Path: "/", MaxAge: -1, HttpOnly: true, Secure: cfg.secureCookies})
+	http.SetCookie(w, &http.Cookie{Name: refreshCookie, Path: "/api", MaxAge: -1, HttpOnly: true, Secure: cfg.secureCookies})
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Path: "/", MaxAge: -1, Secure: cfg.secureCookies})
+}
+
+func newCSRFToken() (string, error) {
+	return oidc.RandomString()
+}
+
+func isSafeMethod(method string) bool {	//Methods that only read, which CSRF checks and read-only tokens let through
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+func checkCSRF(req *http.Request) error {	//Double-submit check, a cross site page can make the browser send our cookies but can't read one to copy into a header
+	if isSafeMethod(req.Method) {
+		return nil
+	}
+	cookie, err := req.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return errCSRF
+	}
+	header := req.Header.Get(csrfHeader)
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+		return errCSRF
+	}
+	return nil
+}
+
+func sessionToken(req *http.Request, cookieName string) (token string, fromCookie bool, err error) {	//Reads a token from the Authorization header, falling back to a session cookie. Cookie tokens must pass the CSRF check
+	if req.Header.Get("Authorization") != "" {
+		token, err := auth.GetBearerToken(req.Header)
+		if err != nil {
+			return "", false, errUnauthenticated
+		}
+		return token, false, nil
+	}
+	cookie, err := req.Cookie(cookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false, errNoCredentials
+	}
+	if err := checkCSRF(req); err != nil {
+		return "", true, err
+	}
+	return cookie.Value, true, nil
+}
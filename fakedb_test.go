@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/database"
+	"github.com/jms-guy/chirpy/internal/revocation"
+)
+
+type fakeResult struct {	//What a faked query returns, rows for :one and :many queries, affected for :exec ones
+	columns []string
+	rows [][]driver.Value
+	affected int64
+}
+
+type fakeQuery func(args []driver.Value) (fakeResult, error)
+
+type fakeDB struct {	//A database/sql driver answering sqlc queries by name, so handlers can be tested without Postgres
+	mu sync.Mutex
+	queries map[string]fakeQuery
+	users map[uuid.UUID]database.User
+	denied map[uuid.UUID]bool	//Access token ids
+	usedLinks map[uuid.UUID]bool	//Magic link ids
+	audits []database.CreateAuditEventParams
+}
+
+func newFakeDB(t *testing.T, cfg *apiConfig) *fakeDB {	//Points cfg's queries and revocation checks at a fresh fakeDB holding users, denied and used token ids and audit events
+	t.Helper()
+	f := &fakeDB{
+		queries: map[string]fakeQuery{},
+		users: map[uuid.UUID]database.User{},
+		denied: map[uuid.UUID]bool{},
+		usedLinks: map[uuid.UUID]bool{},
+	}
+	f.handleUsers()
+	conn := sql.OpenDB(fakeConnector{f})
+	t.Cleanup(func() { conn.Close() })
+	cfg.conn = conn
+	cfg.db = database.New(conn)
+	cfg.revocations = revocation.New(dbRevocationStore{db: cfg.db}, 0)	//No caching, so changes show up on the next request
+	return f
+}
+
+func (f *fakeDB) handle(name string, q fakeQuery) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries[name] = q
+}
+
+func (f *fakeDB) addUser(u database.User) database.User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	if u.Role == "" {
+		u.Role = "user"
+	}
+	u.CreatedAt, u.UpdatedAt = time.Now(), time.Now()
+	f.users[u.ID] = u
+	return u
+}
+
+func (f *fakeDB) user(id uuid.UUID) database.User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.users[id]
+}
+
+func (f *fakeDB) setUser(id uuid.UUID, change func(u *database.User)) {	//For tests changing a user behind the handlers' backs
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updateUser(id, change)
+}
+
+func (f *fakeDB) updateUser(id uuid.UUID, change func(u *database.User)) int64 {	//Called with mu held by the query handlers
+	u, ok := f.users[id]
+	if !ok {
+		return 0
+	}
+	change(&u)
+	f.users[id] = u
+	return 1
+}
+
+func (f *fakeDB) auditActions() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	actions := []string{}
+	for _, a := range f.audits {
+		actions = append(actions, a.Action+":"+a.Outcome)
+	}
+	return actions
+}
+
+func (f *fakeDB) handleUsers() {	//The queries most handlers touch, kept in memory
+	userQuery := func(args []driver.Value) (fakeResult, error) {
+		u, ok := f.users[uuid.MustParse(args[0].(string))]
+		if !ok {
+			return fakeResult{columns: userColumns}, nil
+		}
+		return fakeResult{columns: userColumns, rows: [][]driver.Value{userRow(u)}}, nil
+	}
+	f.queries["GetUserFromID"] = userQuery
+	f.queries["GetUserTokenState"] = func(args []driver.Value) (fakeResult, error) {
+		u, ok := f.users[uuid.MustParse(args[0].(string))]
+		if !ok {
+			return fakeResult{columns: []string{"token_version", "banned_at", "role"}}, nil
+		}
+		return fakeResult{columns: []string{"token_version", "banned_at", "role"}, rows: [][]driver.Value{{int64(u.TokenVersion), nullTime(u.BannedAt), u.Role}}}, nil
+	}
+	f.queries["SoftDeleteUser"] = func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{affected: f.updateUser(uuid.MustParse(args[0].(string)), func(u *database.User) {
+			u.DeletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+			u.TokenVersion++
+		})}, nil
+	}
+	f.queries["SetUserRole"] = func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{affected: f.updateUser(uuid.MustParse(args[1].(string)), func(u *database.User) {
+			u.Role = args[0].(string)
+			u.TokenVersion++
+		})}, nil
+	}
+	f.queries["IncrementTokenVersion"] = func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{affected: f.updateUser(uuid.MustParse(args[0].(string)), func(u *database.User) { u.TokenVersion++ })}, nil
+	}
+	f.queries["UseMagicLink"] = func(args []driver.Value) (fakeResult, error) {
+		id := uuid.MustParse(args[0].(string))
+		if f.usedLinks[id] {
+			return fakeResult{}, nil
+		}
+		f.usedLinks[id] = true
+		return fakeResult{affected: 1}, nil
+	}
+	f.queries["IsAccessTokenDenied"] = func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{columns: []string{"exists"}, rows: [][]driver.Value{{f.denied[uuid.MustParse(args[0].(string))]}}}, nil
+	}
+	f.queries["CreateAuditEvent"] = func(args []driver.Value) (fakeResult, error) {
+		f.audits = append(f.audits, database.CreateAuditEventParams{Action: args[1].(string), Outcome: args[2].(string)})
+		return fakeResult{affected: 1}, nil
+	}
+	for _, name := range []string{"RevokeUserTokens", "RevokeUserPersonalAccessTokens", "RestoreUser"} {
+		f.queries[name] = func(args []driver.Value) (fakeResult, error) { return fakeResult{}, nil }
+	}
+	f.queries["GetSubscription"] = func(args []driver.Value) (fakeResult, error) { return fakeResult{columns: []string{"user_id"}}, nil }	//Nobody subscribes
+	f.queries["CreateToken"] = func(args []driver.Value) (fakeResult, error) {	//Takes token_hash, user_id, expires_at, family_id, session_created_at, user_agent, ip_address and name
+		now := time.Now()
+		return fakeResult{columns: refreshTokenColumns, rows: [][]driver.Value{{args[0], now, now, args[1], args[2], nil, args[3], args[4], now, args[5], args[6], args[7]}}}, nil
+	}
+}
+
+var userColumns = []string{"id", "created_at", "updated_at", "email", "hashed_password", "totp_secret", "totp_enabled_at", "totp_last_step", "email_verified_at", "role", "token_version", "banned_at", "deleted_at", "handle", "display_name", "bio", "location", "website", "avatar_key"}
+
+var refreshTokenColumns = []string{"token_hash", "created_at", "updated_at", "user_id", "expires_at", "revoked_at", "family_id", "session_created_at", "last_used_at", "user_agent", "ip_address", "name"}
+
+func userRow(u database.User) []driver.Value {	//In the order sqlc scans users
+	return []driver.Value{u.ID.String(), u.CreatedAt, u.UpdatedAt, u.Email, u.HashedPassword, nullString(u.TotpSecret), nullTime(u.TotpEnabledAt), u.TotpLastStep,
+		nullTime(u.EmailVerifiedAt), u.Role, int64(u.TokenVersion), nullTime(u.BannedAt), nullTime(u.DeletedAt), nullString(u.Handle), u.DisplayName, u.Bio, u.Location, u.Website, nullString(u.AvatarKey)}
+}
+
+func nullTime(t sql.NullTime) driver.Value {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time
+}
+
+func nullString(s sql.NullString) driver.Value {
+	if !s.Valid {
+		return nil
+	}
+	return s.String
+}
+
+func (f *fakeDB) run(query string, named []driver.NamedValue) (fakeResult, error) {
+	name, _, _ := strings.Cut(strings.TrimPrefix(query, "-- name: "), " ")
+	args := make([]driver.Value, len(named))
+	for i, a := range named {
+		args[i] = a.Value
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q, ok := f.queries[name]
+	if !ok {
+		return fakeResult{}, fmt.Errorf("fakeDB: no handler for %s", name)
+	}
+	return q(args)
+}
+
+type fakeConnector struct{ db *fakeDB }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{c.db}, nil }
+func (c fakeConnector) Driver() driver.Driver { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return nil, fmt.Errorf("fakeDB: use the connector") }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, fmt.Errorf("fakeDB: prepared statements are not supported") }
+func (c fakeConn) Close() error { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }	//Queries run as they come, transactions only group them
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: res.columns, rows: res.rows}, nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	columns []string
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
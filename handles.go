@@ -2,9 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"strings"
+
+	"github.com/jms-guy/chirpy/internal/auth"
 )
 
 func validateHandler(w http.ResponseWriter, req *http.Request) {	//Validates that a post is not longer than the 140 char limit
@@ -48,4 +52,32 @@ func badWordReplacement(text string) string {	//Replaces profanity in post with
 		filtered[i] = word
 	}
 	return strings.Join(filtered, " ")
-}
\ No newline at end of file
+}
+
+func clientIP(req *http.Request) string {	//Returns the IP address a request came from, without the port
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (cfg *apiConfig) checkPasswordPolicy(w http.ResponseWriter, password, email string) bool {	//Responds with the failed rule and returns false when a new password breaks the policy
+	err := cfg.passwordPolicy.Check(password, email)
+	if err == nil {
+		return true
+	}
+	var policyErr *auth.PolicyError
+	if !errors.As(err, &policyErr) {
+		respondWithError(w, 400, "Invalid password")
+		return false
+	}
+	respondWithJSON(w, 400, struct {
+		Error string `json:"error"`
+		Rule string `json:"rule"`	//Which rule failed, for clients to show their own message
+	}{
+		Error: policyErr.Message,
+		Rule: policyErr.Rule,
+	})
+	return false
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"encoding/json"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/jms-guy/chirpy/internal/auth"
 )
 
 func TestBadWordReplacement(t *testing.T) {
@@ -13,4 +17,35 @@ func TestBadWordReplacement(t *testing.T) {
 	if got != result {
 		t.Errorf("got: %s -- wanted: %s", got, result)
 	}
-}
\ No newline at end of file
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/sessions", nil)
+	req.RemoteAddr = "203.0.113.7:52114"
+
+	if got := clientIP(req); got != "203.0.113.7" {
+		t.Errorf("got: %s -- wanted: 203.0.113.7", got)
+	}
+}
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	cfg := &apiConfig{passwordPolicy: auth.DefaultPasswordPolicy}
+
+	rec := httptest.NewRecorder()
+	if cfg.checkPasswordPolicy(rec, "password1", "walt@example.com") {
+		t.Fatal("weak password accepted")
+	}
+	body := struct {
+		Rule string `json:"rule"`
+	}{}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != 400 || body.Rule != auth.RuleTooGuessable {
+		t.Errorf("got status %d and rule %q", rec.Code, body.Rule)
+	}
+
+	if !cfg.checkPasswordPolicy(httptest.NewRecorder(), "correct horse battery staple", "walt@example.com") {
+		t.Error("strong password rejected")
+	}
+}
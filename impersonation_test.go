@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+func serveWithToken(handler http.Handler, method, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func impersonate(t *testing.T, cfg *apiConfig, admin, user database.User, body string) string {	//Mints an impersonation token through the admin route
+	t.Helper()
+	adminToken, err := auth.MakeJWT(auth.AccessToken{UserID: admin.ID, Role: admin.Role, Version: admin.TokenVersion}, cfg.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/admin/users/"+user.ID.String()+"/impersonate", strings.NewReader(body))
+	req.SetPathValue("userId", user.ID.String())
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	rec := httptest.NewRecorder()
+	cfg.requireRole(auth.RoleAdmin, cfg.impersonateHandler).ServeHTTP(rec, req)
+	if rec.Code != 201 {
+		t.Fatalf("impersonating got status %d: %s", rec.Code, rec.Body)
+	}
+	res := struct {
+		Token string `json:"token"`
+	}{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res.Token
+}
+
+func TestReadOnlyImpersonation(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB(t, cfg)
+	admin := db.addUser(database.User{Email: "admin@example.com", Role: auth.RoleAdmin})
+	user := db.addUser(database.User{Email: "user@example.com"})
+	token := impersonate(t, cfg, admin, user, `{"reason":"support ticket 42"}`)
+
+	reached := false
+	handler := func(w http.ResponseWriter, req *http.Request) { reached = true }
+
+	rec := serveWithToken(cfg.requireAuth(auth.ScopeChirpsRead, handler), "GET", token)
+	if rec.Code != 200 || !reached {
+		t.Fatalf("read got status %d, wanted 200", rec.Code)
+	}
+	if rec.Header().Get(impersonatedByHeader) != admin.ID.String() || rec.Header().Get(impersonationModeHeader) != "read-only" {
+		t.Errorf("impersonation headers missing: %v", rec.Header())
+	}
+
+	reached = false
+	if rec := serveWithToken(cfg.requireAuth(auth.ScopeChirpsWrite, handler), "POST", token); rec.Code != 403 || reached {
+		t.Errorf("write got status %d, wanted 403", rec.Code)
+	}
+	reached = false
+	if rec := serveWithToken(cfg.requireAuth("", handler), "GET", token); rec.Code != 403 || reached {	//Such as GET /api/users/me/export
+		t.Errorf("account settings read got status %d, wanted 403", rec.Code)
+	}
+	if actions := db.auditActions(); !slices.Contains(actions, auditImpersonatedRequest+":"+auditFailure) {
+		t.Errorf("refused write not audited: %v", actions)
+	}
+}
+
+func TestImpersonationEndsWithAdmin(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB(t, cfg)
+	admin := db.addUser(database.User{Email: "admin@example.com", Role: auth.RoleAdmin})
+	user := db.addUser(database.User{Email: "user@example.com"})
+	handler := cfg.requireAuth(auth.ScopeChirpsRead, func(w http.ResponseWriter, req *http.Request) {})
+
+	tests := []struct {
+		name string
+		change func(u *database.User)
+	}{
+		{"demoted", func(u *database.User) { u.Role = auth.RoleUser; u.TokenVersion++ }},
+		{"demoted without a version bump", func(u *database.User) { u.Role = auth.RoleModerator }},
+		{"tokens revoked", func(u *database.User) { u.TokenVersion++ }},
+	}
+	for _, tc := range tests {
+		db.setUser(admin.ID, func(u *database.User) { u.Role = auth.RoleAdmin })
+		admin = db.user(admin.ID)
+		token := impersonate(t, cfg, admin, user, `{"reason":"support"}`)
+		if rec := serveWithToken(handler, "GET", token); rec.Code != 200 {
+			t.Fatalf("%s: got status %d before the change, wanted 200", tc.name, rec.Code)
+		}
+		db.setUser(admin.ID, tc.change)
+		if rec := serveWithToken(handler, "GET", token); rec.Code != 401 {
+			t.Errorf("%s: got status %d after the change, wanted 401", tc.name, rec.Code)
+		}
+	}
+}
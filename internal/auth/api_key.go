@@ -3,6 +3,7 @@ package auth
 import (
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 func GetAPIKey(headers http.Header) (string, error) {
@@ -10,6 +11,10 @@ func GetAPIKey(headers http.Header) (string, error) {
 	if apiKey == "" {
 		return "", fmt.Errorf("no api key found")
 	}
-	apiKey = apiKey[len("ApiKey "):]
-	return apiKey, nil
+	scheme, key, ok := strings.Cut(apiKey, " ")
+	key = strings.TrimSpace(key)
+	if !ok || !strings.EqualFold(scheme, "ApiKey") || key == "" {
+		return "", fmt.Errorf("malformed authorization header")
+	}
+	return key, nil
 }
\ No newline at end of file
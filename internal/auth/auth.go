@@ -1,26 +1,161 @@
 package auth
 
 import (
-	"golang.org/x/crypto/bcrypt"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {	//Takes a password string and hashes it
-	pass := []byte(password)
+type Argon2Params struct {	//Cost settings for new password hashes, stored in each hash so they can change over time
+	Memory uint32	//KiB
+	Iterations uint32
+	Parallelism uint8
+	SaltLength uint32
+	KeyLength uint32
+}
 
-	hash, err := bcrypt.GenerateFromPassword(pass, bcrypt.DefaultCost)
-	if err != nil {
-		return "Error hashing password", err
+var DefaultArgon2Params = Argon2Params{	//OWASP's recommended minimum for Argon2id
+	Memory: 19 * 1024,
+	Iterations: 2,
+	Parallelism: 1,
+	SaltLength: 16,
+	KeyLength: 32,
+}
+
+var (
+	paramsMu sync.RWMutex
+	currentParams = DefaultArgon2Params
+)
+
+func SetArgon2Params(p Argon2Params) error {	//Changes the cost used for new hashes. Existing hashes are upgraded as users log in
+	if p.Memory < 8*uint32(p.Parallelism) || p.Iterations < 1 || p.Parallelism < 1 || p.SaltLength < 8 || p.KeyLength < 16 {
+		return fmt.Errorf("invalid argon2 parameters %+v", p)
+	}
+	paramsMu.Lock()
+	currentParams = p
+	paramsMu.Unlock()
+	return nil
+}
+
+func argon2Params() Argon2Params {
+	paramsMu.RLock()
+	defer paramsMu.RUnlock()
+	return currentParams
+}
+
+var b64 = base64.RawStdEncoding	//PHC strings use unpadded standard base64
+
+func HashPassword(password string) (string, error) {	//Hashes a password with Argon2id, returning a PHC format string
+	p := argon2Params()
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
 	}
-	return string(hash), nil
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
 }
 
-func CheckPasswordHash(hash, password string) error {	//Checks a given password against a hash 
-	pass := []byte(password)
+func CheckPasswordHash(hash, password string) error {	//Checks a given password against an Argon2id or legacy bcrypt hash
+	if strings.HasPrefix(hash, "$argon2id$") {
+		p, salt, key, err := parseArgon2Hash(hash)
+		if err != nil {
+			return err
+		}
+		got := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		if subtle.ConstantTimeCompare(got, key) != 1 {
+			return fmt.Errorf("error comparing password against hash: password does not match")
+		}
+		return nil
+	}
 
-	err := bcrypt.CompareHashAndPassword([]byte(hash), pass)
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	if err != nil {
 		return fmt.Errorf("error comparing password against hash: %w", err)
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+func NeedsRehash(hash string) bool {	//Reports whether a hash uses an old algorithm or different cost than new hashes would
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		return true
+	}
+	p, salt, key, err := parseArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	want := argon2Params()
+	return p.Memory != want.Memory || p.Iterations != want.Iterations || p.Parallelism != want.Parallelism ||
+		uint32(len(salt)) != want.SaltLength || uint32(len(key)) != want.KeyLength
+}
+
+func parseArgon2Hash(hash string) (Argon2Params, []byte, []byte, error) {	//Splits $argon2id$v=19$m=..,t=..,p=..$salt$key into its parts
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	p := Argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2 parameters: %w", err)
+	}
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2 salt: %w", err)
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2 key: %w", err)
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
+
+var (
+	dummyHash string
+	dummyHashOnce sync.Once
+	checkFloor time.Duration
+	checkFloorOnce sync.Once
+)
+
+const NoPassword = "unset"	//Stored for accounts with no password of their own, such as those created through single sign-on. It matches the column default and no password ever matches it
+
+func HasPassword(hash string) bool {	//Reports whether a stored hash is a real password hash, which all start with $
+	return strings.HasPrefix(hash, "$")
+}
+
+func CheckDummyPassword(password string) {	//Spends the same time as a real password check, for logins to accounts that don't exist
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = HashPassword("chirpy-dummy-password")
+	})
+	_ = CheckPasswordHash(dummyHash, password)
+}
+
+func PadPasswordCheck(start time.Time) {	//Sleeps until the slowest password check would have finished, so a failed login takes as long whether it was checked against bcrypt, Argon2id or nothing at all
+	time.Sleep(passwordCheckFloor() - time.Since(start))
+}
+
+func passwordCheckFloor() time.Duration {	//Measured once, on first use, so it reflects the Argon2 settings in force
+	checkFloorOnce.Do(func() {
+		legacy, _ := bcrypt.GenerateFromPassword([]byte("chirpy-dummy-password"), bcrypt.DefaultCost)	//The cost accounts from before Argon2id were hashed with
+		current, _ := HashPassword("chirpy-dummy-password")
+		for _, hash := range []string{string(legacy), current} {
+			start := time.Now()
+			_ = CheckPasswordHash(hash, "wrong")
+			checkFloor = max(checkFloor, time.Since(start))
+		}
+		checkFloor += checkFloor / 10	//Headroom for a check that runs slower than the one measured
+	})
+	return checkFloor
+}
@@ -1,12 +1,20 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestPasswords(t *testing.T) {
@@ -21,6 +29,77 @@ func TestPasswords(t *testing.T) {
 	if checkErr != nil {
 		t.Errorf("Failed check: %s", err)
 	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("unexpected hash format %s", hash)
+	}
+	if CheckPasswordHash(hash, "Thisismypassword!") == nil {
+		t.Error("wrong password accepted")
+	}
+}
+
+func TestPadPasswordCheck(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_ = CheckPasswordHash(string(legacy), "wrong")
+	slowest := time.Since(start)
+
+	start = time.Now()
+	CheckDummyPassword("wrong")
+	PadPasswordCheck(start)
+	if padded := time.Since(start); padded < slowest*3/4 {	//Leaves room for the two bcrypt checks not taking exactly as long
+		t.Errorf("unknown account check took %s, a bcrypt check %s", padded, slowest)
+	}
+}
+
+func TestLegacyBcryptHash(t *testing.T) {
+	long := strings.Repeat("a", 72)
+	legacy, err := bcrypt.GenerateFromPassword([]byte(long), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPasswordHash(string(legacy), long); err != nil {
+		t.Errorf("bcrypt hash rejected: %s", err)
+	}
+	if !NeedsRehash(string(legacy)) {
+		t.Error("bcrypt hashes should be upgraded")
+	}
+
+	hash, err := HashPassword(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CheckPasswordHash(hash, long+"b") == nil {	//bcrypt would have ignored the extra byte
+		t.Error("argon2id should use the whole password")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hash, err := HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsRehash(hash) {
+		t.Error("hash with current parameters should not need a rehash")
+	}
+
+	stronger := DefaultArgon2Params
+	stronger.Iterations = 3
+	if err := SetArgon2Params(stronger); err != nil {
+		t.Fatal(err)
+	}
+	defer SetArgon2Params(DefaultArgon2Params)
+	if !NeedsRehash(hash) {
+		t.Error("hash with old parameters should need a rehash")
+	}
+	if err := CheckPasswordHash(hash, "password"); err != nil {
+		t.Errorf("old hash should still verify: %s", err)
+	}
+	if SetArgon2Params(Argon2Params{}) == nil {
+		t.Error("zero parameters accepted")
+	}
 }
 
 func TestToken(t *testing.T) {
@@ -28,21 +107,186 @@ func TestToken(t *testing.T) {
 	idBytes, _ := hex.DecodeString(idString)
 	id, _ := uuid.FromBytes(idBytes)
 
-	secret := "Thisisatokensecret"
+	keys := testKeyRing(t, "k1")
 
-	signature, err := MakeJWT(id, secret)
+	signature, err := MakeJWT(AccessToken{UserID: id, Role: RoleUser}, keys)
 	if err != nil {
 		t.Error(err)
 	}
 
 	//time.Sleep(6 * time.Second)
-	user, err := ValidateJWT(signature, secret)
+	user, err := ValidateJWT(signature, keys)
 	if err != nil {
 		t.Error(err)
 	}
 	fmt.Println(user)
 }
 
+func TestAccessTokenClaims(t *testing.T) {
+	keys := testKeyRing(t, "k1")
+	want := AccessToken{UserID: uuid.New(), Role: RoleAdmin, SessionID: uuid.New(), Version: 3, AuthTime: time.Now().Add(-time.Hour).Truncate(time.Second)}
+
+	first, err := MakeJWT(want, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := MakeJWT(want, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := ValidateJWT(first, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ValidateJWT(second, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.UserID != want.UserID || a.Role != want.Role || a.SessionID != want.SessionID || a.Version != want.Version || !a.AuthTime.Equal(want.AuthTime) {
+		t.Errorf("got %+v, wanted %+v", a, want)
+	}
+	if a.ID == uuid.Nil || a.ID == b.ID {
+		t.Error("each token should get its own id")
+	}
+	if a.ExpiresAt.IsZero() {
+		t.Error("expiry was not read back")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	id := uuid.New()
+	keys := testKeyRing(t, "old")
+
+	oldToken, err := MakeJWT(AccessToken{UserID: id, Role: RoleUser}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := NewSigningKey("new", rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Add(newKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.SetCurrent("new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Retire("old"); err != nil {
+		t.Fatal(err)
+	}
+
+	newToken, err := MakeJWT(AccessToken{UserID: id, Role: RoleUser}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{oldToken, newToken} {	//Tokens from the retired key still validate alongside the new one
+		got, err := ValidateJWT(token, keys)
+		if err != nil {
+			t.Errorf("validating token: %s", err)
+		}
+		if got.UserID != id || got.Role != RoleUser {
+			t.Errorf("got: %v -- wanted: %s with role %s", got, id, RoleUser)
+		}
+	}
+
+	if len(keys.JWKS().Keys) != 2 {
+		t.Errorf("expected both keys in JWKS, got %d", len(keys.JWKS().Keys))
+	}
+
+	keys.Remove("old")
+	if _, err := ValidateJWT(oldToken, keys); err == nil {
+		t.Error("token signed with a removed key should not validate")
+	}
+}
+
+func TestRetireWhileSigning(t *testing.T) {	//Run with -race, signers must never see a key change under them
+	keys := testKeyRing(t, "k0")
+	id := uuid.New()
+
+	stop := make(chan struct{})
+	errs := make(chan error, 4)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				token, err := MakeJWT(AccessToken{UserID: id, Role: RoleUser}, keys)
+				if err == nil {
+					_, err = ValidateJWT(token, keys)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 1; i <= 500; i++ {
+		key, err := GenerateSigningKey(fmt.Sprintf("k%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keys.Add(key); err != nil {
+			t.Fatal(err)
+		}
+		if err := keys.SetCurrent(key.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := keys.Retire(fmt.Sprintf("k%d", i-1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("signing during rotation: %s", err)
+	}
+}
+
+func TestValidateJWTRejectsHS256(t *testing.T) {
+	keys := testKeyRing(t, "k1")
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer: "chirpy",
+		Subject: hex.EncodeToString(make([]byte, 16)),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString([]byte("Thisisatokensecret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(signed, keys); err == nil {
+		t.Error("HS256 token should not validate against an EdDSA key")
+	}
+}
+
+func testKeyRing(t *testing.T, kid string) *KeyRing {
+	t.Helper()
+	key, err := GenerateSigningKey(kid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewKeyRing()
+	if err := keys.Add(key); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
 func TestGetBearerToken(t *testing.T) {
 	headers := http.Header{}
 	headers.Add("Authorization", "Bearer TOKEN_STRING")
@@ -57,4 +301,248 @@ func TestGetBearerToken(t *testing.T) {
 	} else {
 		t.Error("fail")
 	}
-}
\ No newline at end of file
+
+	for _, header := range []string{"TOKEN_STRING", "Bearer", "Bearer ", "Basic dXNlcjpwYXNz", "Tok"} {
+		headers := http.Header{}
+		headers.Set("Authorization", header)
+		if _, err := GetBearerToken(headers); err == nil {
+			t.Errorf("expected error for header %q", header)
+		}
+	}
+}
+func TestHashToken(t *testing.T) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := HashToken(token)
+	if hash == token {
+		t.Error("hash should not equal the raw token")
+	}
+	if hash != HashToken(token) {
+		t.Error("hashing the same token twice should give the same digest")
+	}
+	if got, want := HashToken("abc"), "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"; got != want {	//Must match Postgres' sha256() used by the migration
+		t.Errorf("got: %s -- wanted: %s", got, want)
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"	//RFC 6238 SHA1 test key "12345678901234567890"
+	tests := map[int64]string{
+		59: "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+	}
+	for unix, want := range tests {
+		got, err := TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("at %d got: %s -- wanted: %s", unix, got, want)
+		}
+	}
+
+	now := time.Unix(1111111109, 0)
+	step, err := ValidateTOTP(secret, "081804", now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateTOTP(secret, "081804", now, step); err == nil {
+		t.Error("a code should not validate twice")
+	}
+	if _, err := ValidateTOTP(secret, "000000", now, 0); err == nil {
+		t.Error("wrong code should not validate")
+	}
+}
+
+func TestChallengeTokenIsNotAccessToken(t *testing.T) {
+	keys := testKeyRing(t, "k1")
+	id := uuid.New()
+
+	challenge, err := MakeChallengeJWT(id, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(challenge, keys); err == nil {
+		t.Error("challenge token should not validate as an access token")
+	}
+	got, err := ValidateChallengeJWT(challenge, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != id {
+		t.Errorf("got: %s -- wanted: %s", got, id)
+	}
+}
+
+func TestImpersonationJWT(t *testing.T) {
+	keys := testKeyRing(t, "k1")
+	user, admin := uuid.New(), uuid.New()
+
+	token, err := MakeJWT(AccessToken{UserID: user, Role: RoleUser, ActorID: admin, ReadOnly: true, TTL: 10 * time.Minute}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateJWT(token, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != user || claims.ActorID != admin || !claims.ReadOnly {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if time.Until(claims.ExpiresAt) > 10*time.Minute {
+		t.Errorf("TTL not applied, expires at %s", claims.ExpiresAt)
+	}
+
+	plain, err := MakeJWT(AccessToken{UserID: user, Role: RoleUser}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = ValidateJWT(plain, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.ActorID != uuid.Nil || claims.ReadOnly {
+		t.Errorf("ordinary token marked as impersonation %+v", claims)
+	}
+	if time.Until(claims.ExpiresAt) < 59*time.Minute {
+		t.Errorf("default lifetime not an hour, expires at %s", claims.ExpiresAt)
+	}
+}
+
+func TestMagicLinkJWT(t *testing.T) {
+	keys := testKeyRing(t, "k1")
+	id := uuid.New()
+
+	token, err := MakeMagicLinkJWT(id, "user@example.com", HashToken("nonce"), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(token, keys); err == nil {
+		t.Error("magic link should not validate as an access token")
+	}
+	if _, err := ValidateChallengeJWT(token, keys); err == nil {
+		t.Error("magic link should not validate as a challenge token")
+	}
+
+	link, err := ValidateMagicLinkJWT(token, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.UserID != id || link.Email != "user@example.com" || link.NonceHash != HashToken("nonce") {
+		t.Errorf("unexpected link %+v", link)
+	}
+	if link.ID == uuid.Nil || time.Until(link.ExpiresAt) > MagicLinkTTL {
+		t.Errorf("bad id or expiry %+v", link)
+	}
+
+	other, _ := MakeMagicLinkJWT(id, "user@example.com", HashToken("nonce"), keys)
+	if again, _ := ValidateMagicLinkJWT(other, keys); again.ID == link.ID {
+		t.Error("each link should get its own id")
+	}
+}
+
+func TestPersonalAccessToken(t *testing.T) {
+	token, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPersonalAccessToken(token) {
+		t.Errorf("%s should be recognised as a personal access token", token)
+	}
+	if IsPersonalAccessToken("eyJhbGciOiJFZERTQSJ9.e30.sig") {
+		t.Error("a JWT should not be recognised as a personal access token")
+	}
+	if !ValidScope(ScopeChirpsWrite) || ValidScope("admin:everything") {
+		t.Error("scope validation is wrong")
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		role, required string
+		want bool
+	}{
+		{RoleAdmin, RoleModerator, true},
+		{RoleModerator, RoleModerator, true},
+		{RoleUser, RoleModerator, false},
+		{"", RoleUser, false},
+		{"superuser", RoleUser, false},
+	}
+	for _, tc := range tests {
+		if got := HasRole(tc.role, tc.required); got != tc.want {
+			t.Errorf("HasRole(%q, %q) got: %v -- wanted: %v", tc.role, tc.required, got, tc.want)
+		}
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := DefaultPasswordPolicy
+	policy.Breached = NewBreachedList("Tr0ub4dor&3")
+
+	tests := []struct {
+		password string
+		rule string
+	}{
+		{"", RuleMinLength},
+		{"short", RuleMinLength},
+		{strings.Repeat("x", 1025), RuleMaxLength},
+		{"walter@example.com", RuleContainsEmail},
+		{"xx-walter-2024-yy", RuleContainsEmail},
+		{"password1", RuleTooGuessable},
+		{"qwertyuiop", RuleTooGuessable},
+		{"Tr0ub4dor&3", RuleBreached},
+		{"correct horse battery staple", ""},
+	}
+	for _, tc := range tests {
+		err := policy.Check(tc.password, "Walter@example.com")
+		if tc.rule == "" {
+			if err != nil {
+				t.Errorf("%q rejected: %s", tc.password, err)
+			}
+			continue
+		}
+		policyErr, ok := err.(*PolicyError)
+		if !ok || policyErr.Rule != tc.rule {
+			t.Errorf("%q: got %v, wanted rule %s", tc.password, err, tc.rule)
+		}
+	}
+}
+
+func TestEstimateEntropyLongPasswords(t *testing.T) {
+	if bits := EstimateEntropy(strings.Repeat("a", 200)); bits > 35 {	//Runs longer than maxPatternLength still count as cheap
+		t.Errorf("repeated character scored %.1f bits", bits)
+	}
+
+	long := strings.Repeat("k9#Tq", maxPasswordLength/5)
+	start := time.Now()
+	EstimateEntropy(long)
+	if took := time.Since(start); took > 200*time.Millisecond {	//Checked on unauthenticated routes, so it must stay cheap at the length limit
+		t.Errorf("estimating a %d character password took %s", len(long), took)
+	}
+}
+
+func TestLoadBreachedList(t *testing.T) {
+	path := t.TempDir() + "/breached.txt"
+	content := "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\n\n7C4A8D09CA3762AF61E59520943DC26494F8941B:37359195\n"	//"password" and "123456"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	list, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Len() != 2 || !list.Contains("password") || !list.Contains("123456") || list.Contains("hunter2") {
+		t.Error("breached list lookups are wrong")
+	}
+
+	if err := os.WriteFile(path, []byte("not-a-hash\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBreachedList(path); err == nil {
+		t.Error("malformed file accepted")
+	}
+}
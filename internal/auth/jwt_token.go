@@ -4,30 +4,252 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+const (
+	accessAudience = "chirpy"
+	challengeAudience = "chirpy-2fa"
+	verifyEmailAudience = "chirpy-verify-email"
+	magicLinkAudience = "chirpy-magic-link"
+)
+
+const MagicLinkTTL = 15 * time.Minute
+
+type AccessToken struct {	//What a valid access token says about its bearer
+	UserID uuid.UUID
+	Role string
+	ID uuid.UUID	//The jti, set by MakeJWT
+	SessionID uuid.UUID	//Refresh token family the token was issued from, uuid.Nil if none
+	Version int32	//The user's token version when issued, bumping it voids every older token
+	ActorID uuid.UUID	//Admin acting as UserID for an impersonation token, uuid.Nil otherwise
+	ActorVersion int32	//The admin's token version when the impersonation token was issued
+	ReadOnly bool	//Bearer may only make safe requests, set on impersonation tokens unless asked otherwise
+	TTL time.Duration	//Lifetime given by MakeJWT, zero for the usual hour
+	AuthTime time.Time	//When the user last logged in, carried over on refresh. Zero when unknown
+	ExpiresAt time.Time	//Set by ValidateJWT
+}
+
+type accessClaims struct {
+	Role string `json:"role"`
+	SessionID string `json:"sid,omitempty"`
+	Version int32 `json:"ver"`
+	Actor *actorClaim `json:"act,omitempty"`	//RFC 8693 actor, the party really making requests with the token
+	ReadOnly bool `json:"ro,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`	//As in OpenID Connect
+	jwt.RegisteredClaims
+}
+
+type actorClaim struct {
+	Subject string `json:"sub"`
+	Version int32 `json:"ver"`
+}
+
+type emailClaims struct {	//Claims for tokens tied to a specific email address, so changing the address voids them
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+type MagicLink struct {	//What a valid magic login link says
+	UserID uuid.UUID
+	Email string	//Address the link was sent to
+	NonceHash string	//HashToken of the nonce cookie given to the browser that asked for the link
+	ID uuid.UUID	//The jti, spent when the link is used
+	ExpiresAt time.Time
+}
+
+type magicLinkClaims struct {
+	Email string `json:"email"`
+	NonceHash string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
 func GetBearerToken(headers http.Header) (string, error) {	//Gets bearer authorization token from request header
 	tokenString := headers.Get("Authorization")
 	if tokenString == "" {
 		return "", fmt.Errorf("no token found")
 	}
-	tokenString = tokenString[len("Bearer "):]
-	return tokenString, nil
+	scheme, token, ok := strings.Cut(tokenString, " ")
+	token = strings.TrimSpace(token)
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", fmt.Errorf("malformed authorization header")
+	}
+	return token, nil
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {	//Generates a JWT authorization token
-	claims := jwt.RegisteredClaims{	//Creates claims payload for token
+func MakeJWT(token AccessToken, keys *KeyRing) (string, error) {	//Generates a JWT authorization token
+	ttl := token.TTL
+	if ttl <= 0 {
+		ttl = 1 * time.Hour
+	}
+	claims := accessClaims{	//Creates claims payload for token
+		Role: token.Role,
+		Version: token.Version,
+		ReadOnly: token.ReadOnly,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID: uuid.NewString(),	//Lets a single token be put on the deny-list
+			Issuer: "chirpy",
+			IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(ttl)),
+			Subject: hex.EncodeToString(token.UserID[:]),	//Encode uuid into string form
+			Audience: jwt.ClaimStrings{accessAudience},
+		},
+	}
+	if token.SessionID != uuid.Nil {
+		claims.SessionID = token.SessionID.String()
+	}
+	if !token.AuthTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(token.AuthTime)
+	}
+	if token.ActorID != uuid.Nil {
+		claims.Actor = &actorClaim{Subject: hex.EncodeToString(token.ActorID[:]), Version: token.ActorVersion}
+	}
+	return signClaims(claims, keys)
+}
+
+func ValidateJWT(tokenString string, keys *KeyRing) (AccessToken, error) {	//Takes a token string, and validates it against the public keys in the key ring
+	claims := accessClaims{}
+	if err := parseClaims(tokenString, &claims, keys, accessAudience); err != nil {
+		return AccessToken{}, err
+	}
+	id, err := subjectID(&claims)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	jti, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return AccessToken{}, fmt.Errorf("token has no valid id: %w", err)
+	}
+	sessionID := uuid.Nil
+	if claims.SessionID != "" {
+		if sessionID, err = uuid.Parse(claims.SessionID); err != nil {
+			return AccessToken{}, fmt.Errorf("token has an invalid session id: %w", err)
+		}
+	}
+	actorID, actorVersion := uuid.Nil, int32(0)
+	if claims.Actor != nil {
+		actorVersion = claims.Actor.Version
+		actorBytes, err := hex.DecodeString(claims.Actor.Subject)
+		if err != nil {
+			return AccessToken{}, fmt.Errorf("token has an invalid actor: %w", err)
+		}
+		if actorID, err = uuid.FromBytes(actorBytes); err != nil {
+			return AccessToken{}, fmt.Errorf("token has an invalid actor: %w", err)
+		}
+	}
+	token := AccessToken{
+		UserID: id,
+		Role: claims.Role,
+		ID: jti,
+		SessionID: sessionID,
+		Version: claims.Version,
+		ActorID: actorID,
+		ActorVersion: actorVersion,
+		ReadOnly: claims.ReadOnly,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+	if claims.AuthTime != nil {
+		token.AuthTime = claims.AuthTime.Time
+	}
+	return token, nil
+}
+
+func MakeChallengeJWT(userID uuid.UUID, keys *KeyRing) (string, error) {	//Generates a short lived token proving the password step of a two-factor login passed
+	claims := jwt.RegisteredClaims{
 		Issuer: "chirpy",
 		IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(1 * time.Hour)),
-		Subject: hex.EncodeToString(userID[:]),	//Encode uuid into string form
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(5 * time.Minute)),
+		Subject: hex.EncodeToString(userID[:]),
+		Audience: jwt.ClaimStrings{challengeAudience},	//Keeps challenge tokens from being accepted as access tokens
+	}
+	return signClaims(claims, keys)
+}
+
+func ValidateChallengeJWT(tokenString string, keys *KeyRing) (uuid.UUID, error) {
+	claims := jwt.RegisteredClaims{}
+	if err := parseClaims(tokenString, &claims, keys, challengeAudience); err != nil {
+		return uuid.UUID{}, err
+	}
+	return subjectID(&claims)
+}
+
+func MakeEmailVerificationJWT(userID uuid.UUID, email string, keys *KeyRing) (string, error) {	//Generates the token sent in email verification links
+	claims := emailClaims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "chirpy",
+			IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(24 * time.Hour)),
+			Subject: hex.EncodeToString(userID[:]),
+			Audience: jwt.ClaimStrings{verifyEmailAudience},
+		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)	//Create a token with claims payload 
+	return signClaims(claims, keys)
+}
 
-	s, err := token.SignedString([]byte(tokenSecret))	//Create a verification signature of the token based off the tokenSecret string
+func ValidateEmailVerificationJWT(tokenString string, keys *KeyRing) (uuid.UUID, string, error) {	//Returns the user and the email address the link was sent to
+	claims := emailClaims{}
+	if err := parseClaims(tokenString, &claims, keys, verifyEmailAudience); err != nil {
+		return uuid.UUID{}, "", err
+	}
+	id, err := subjectID(&claims)
+	if err != nil {
+		return uuid.UUID{}, "", err
+	}
+	return id, claims.Email, nil
+}
+
+func MakeMagicLinkJWT(userID uuid.UUID, email, nonceHash string, keys *KeyRing) (string, error) {	//Generates the token sent in passwordless login links
+	now := time.Now().UTC()
+	claims := magicLinkClaims{
+		Email: email,
+		NonceHash: nonceHash,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "chirpy",
+			IssuedAt: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(MagicLinkTTL)),
+			Subject: hex.EncodeToString(userID[:]),
+			Audience: jwt.ClaimStrings{magicLinkAudience},
+			ID: uuid.NewString(),
+		},
+	}
+	return signClaims(claims, keys)
+}
+
+func ValidateMagicLinkJWT(tokenString string, keys *KeyRing) (MagicLink, error) {	//Checks a magic link's signature and expiry. Binding to the browser and single use are left to the caller
+	claims := magicLinkClaims{}
+	if err := parseClaims(tokenString, &claims, keys, magicLinkAudience); err != nil {
+		return MagicLink{}, err
+	}
+	userID, err := subjectID(&claims)
+	if err != nil {
+		return MagicLink{}, err
+	}
+	id, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return MagicLink{}, fmt.Errorf("error parsing token id: %w", err)
+	}
+	return MagicLink{
+		UserID: userID,
+		Email: claims.Email,
+		NonceHash: claims.NonceHash,
+		ID: id,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
+
+func signClaims(claims jwt.Claims, keys *KeyRing) (string, error) {	//Signs a claims payload with the ring's current key, naming the key in the kid header
+	key, err := keys.signingKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.Method, claims)	//Create a token with claims payload 
+	token.Header["kid"] = key.ID
+
+	s, err := token.SignedString(key.Private)	//Create a verification signature of the token with the private key
 	if err != nil {
 		return "", err
 	}
@@ -35,14 +257,16 @@ func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {	//Generates
 	return s, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {	//Takes a token string, and validates it based off a stored secret string
-	parsedToken, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(t *jwt.Token) (any, error) {	//Parses the token string into a claims token
-		return []byte(tokenSecret), nil
-	})
+func parseClaims(tokenString string, claims jwt.Claims, keys *KeyRing, audience string) error {	//Parses a token string into claims, checking its signature against the ring and that it was issued for audience
+	_, err := jwt.ParseWithClaims(tokenString, claims, keys.verificationKey, jwt.WithIssuer("chirpy"), jwt.WithAudience(audience), jwt.WithExpirationRequired())
 	if err != nil {
-		return uuid.UUID{}, fmt.Errorf("token is invalid or expired")
+		return fmt.Errorf("token is invalid or expired")
 	}
-	idString, err := parsedToken.Claims.GetSubject()	//Gets userID string from token payload
+	return nil
+}
+
+func subjectID(claims jwt.Claims) (uuid.UUID, error) {	//Decodes the hex encoded user id stored in a token's subject
+	idString, err := claims.GetSubject()	//Gets userID string from token payload
 	if err != nil {
 		return uuid.UUID{}, fmt.Errorf("error getting id from token: %w", err)
 	}
@@ -58,4 +282,4 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {	//Takes a
 	}
 
 	return id, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type SigningKey struct {	//A single key in the ring, identified by the kid placed in token headers
+	ID string
+	Method jwt.SigningMethod
+	Private crypto.Signer	//Nil for retired keys, which can only verify
+	Public crypto.PublicKey
+}
+
+func (k *SigningKey) Retired() bool {
+	return k.Private == nil
+}
+
+type KeyRing struct {	//Holds every key tokens may be verified with, and the one new tokens are signed with
+	mu sync.RWMutex
+	keys map[string]*SigningKey	//Never changed once added, signers use them without holding mu. Changes replace the entry
+	current string
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: map[string]*SigningKey{}}
+}
+
+func NewSigningKey(kid string, key any) (*SigningKey, error) {	//Wraps a parsed RSA or Ed25519 key, private keys sign and public keys only verify
+	k := &SigningKey{ID: kid}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		k.Method, k.Private, k.Public = jwt.SigningMethodRS256, key, &key.PublicKey
+	case *rsa.PublicKey:
+		k.Method, k.Public = jwt.SigningMethodRS256, key
+	case ed25519.PrivateKey:
+		k.Method, k.Private, k.Public = jwt.SigningMethodEdDSA, key, key.Public()
+	case ed25519.PublicKey:
+		k.Method, k.Public = jwt.SigningMethodEdDSA, key
+	default:
+		return nil, fmt.Errorf("unsupported key type %T for key %s", key, kid)
+	}
+	return k, nil
+}
+
+func GenerateSigningKey(kid string) (*SigningKey, error) {	//Creates a fresh Ed25519 signing key, used when no keys are configured
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating key: %w", err)
+	}
+	return NewSigningKey(kid, priv)
+}
+
+func LoadKeyRing(dir, currentKid string) (*KeyRing, error) {	//Loads every <kid>.pem file in dir. Private keys are active, public keys are retired
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", dir)
+	}
+	sort.Strings(files)
+
+	ring := NewKeyRing()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading key file: %w", err)
+		}
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		key, err := parsePEMKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing key %s: %w", kid, err)
+		}
+		signingKey, err := NewSigningKey(kid, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := ring.Add(signingKey); err != nil {
+			return nil, err
+		}
+		if currentKid == "" && !signingKey.Retired() {
+			ring.current = kid	//Without an explicit choice, the last active key by name signs
+		}
+	}
+
+	if currentKid != "" {
+		if err := ring.SetCurrent(currentKid); err != nil {
+			return nil, err
+		}
+	}
+	if ring.current == "" {
+		return nil, fmt.Errorf("no active signing key in %s", dir)
+	}
+	return ring, nil
+}
+
+func parsePEMKey(data []byte) (any, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+}
+
+func (kr *KeyRing) Add(key *SigningKey) error {	//Adds a key to the ring, the first active key added becomes the signing key
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, ok := kr.keys[key.ID]; ok {
+		return fmt.Errorf("duplicate key id %s", key.ID)
+	}
+	stored := *key	//A copy, so the caller changing key can't affect signers
+	kr.keys[key.ID] = &stored
+	if kr.current == "" && !key.Retired() {
+		kr.current = key.ID
+	}
+	return nil
+}
+
+func (kr *KeyRing) SetCurrent(kid string) error {	//Switches which active key new tokens are signed with
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	key, ok := kr.keys[kid]
+	if !ok {
+		return fmt.Errorf("unknown key id %s", kid)
+	}
+	if key.Retired() {
+		return fmt.Errorf("key %s is retired and cannot sign", kid)
+	}
+	kr.current = kid
+	return nil
+}
+
+func (kr *KeyRing) Retire(kid string) error {	//Drops a key's private half so it keeps verifying outstanding tokens but signs no more
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	key, ok := kr.keys[kid]
+	if !ok {
+		return fmt.Errorf("unknown key id %s", kid)
+	}
+	if kid == kr.current {
+		return fmt.Errorf("key %s is the current signing key", kid)
+	}
+	retired := *key	//Signers may still hold the old entry, so it is replaced rather than changed
+	retired.Private = nil
+	kr.keys[kid] = &retired
+	return nil
+}
+
+func (kr *KeyRing) Remove(kid string) {	//Removes a key entirely, tokens signed with it stop validating
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if kid == kr.current {
+		return
+	}
+	delete(kr.keys, kid)
+}
+
+func (kr *KeyRing) signingKey() (*SigningKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	key, ok := kr.keys[kr.current]
+	if !ok {
+		return nil, fmt.Errorf("no signing key configured")
+	}
+	return key, nil
+}
+
+func (kr *KeyRing) verificationKey(t *jwt.Token) (any, error) {	//jwt.Keyfunc that picks the key named by the token's kid header
+	kid, ok := t.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("token has no kid header")
+	}
+	kr.mu.RLock()
+	key, ok := kr.keys[kid]
+	kr.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+	if t.Method.Alg() != key.Method.Alg() {	//Never let the token choose a different algorithm than the key was made for
+		return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+func (kr *KeyRing) JWKS() JWKS {	//Returns the public half of every key in the ring, in JWK Set format
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	kids := make([]string, 0, len(kr.keys))
+	for kid := range kr.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := JWKS{Keys: []JWK{}}
+	for _, kid := range kids {
+		key := kr.keys[kid]
+		jwk := JWK{Kid: kid, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleContainsEmail = "contains_email"
+	RuleTooGuessable = "too_guessable"
+	RuleBreached = "breached"
+)
+
+const maxPasswordLength = 1024	//Stops huge inputs being fed to the hasher
+
+const maxPatternLength = 32	//Longest segment EstimateEntropy tries every start for. Only repeats and sequences are matched past it, which keeps long passwords cheap to check
+
+type PolicyError struct {	//A password rule that failed, Rule is one of the Rule constants
+	Rule string
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+type PasswordPolicy struct {
+	MinLength int	//Counted in characters, not bytes
+	MinEntropyBits float64	//Guessability floor from EstimateEntropy, zero turns the check off
+	Breached *BreachedList	//Nil skips the breached password check
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength: 8,
+	MinEntropyBits: 35,
+}
+
+func (p PasswordPolicy) Check(password, email string) error {	//Returns a *PolicyError for the first rule the password breaks, checked cheapest first
+	length := len([]rune(password))
+	if length < p.MinLength {
+		return &PolicyError{Rule: RuleMinLength, Message: fmt.Sprintf("Password must be at least %d characters", p.MinLength)}
+	}
+	if len(password) > maxPasswordLength {
+		return &PolicyError{Rule: RuleMaxLength, Message: fmt.Sprintf("Password must be at most %d bytes", maxPasswordLength)}
+	}
+
+	lower := strings.ToLower(password)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email != "" {
+		local, _, _ := strings.Cut(email, "@")
+		if lower == email || (len(local) >= 3 && strings.Contains(lower, local)) {
+			return &PolicyError{Rule: RuleContainsEmail, Message: "Password must not contain your email address"}
+		}
+	}
+
+	if p.MinEntropyBits > 0 && EstimateEntropy(password) < p.MinEntropyBits {
+		return &PolicyError{Rule: RuleTooGuessable, Message: "Password is too easy to guess, try a longer phrase or fewer common words and patterns"}
+	}
+	if p.Breached != nil && p.Breached.Contains(password) {
+		return &PolicyError{Rule: RuleBreached, Message: "Password has appeared in a data breach, please choose another"}
+	}
+	return nil
+}
+
+var commonWords = []string{	//Most common first, a word's rank sets how cheap it is to guess
+	"password", "123456", "qwerty", "letmein", "welcome", "admin", "dragon", "monkey",
+	"football", "iloveyou", "princess", "sunshine", "master", "shadow", "baseball", "superman",
+	"trustno1", "starwars", "whatever", "michael", "charlie", "jordan", "hunter", "buster",
+	"soccer", "harley", "batman", "andrew", "tigger", "jessica", "pepper", "ginger",
+	"summer", "winter", "spring", "autumn", "freedom", "secret", "chirpy", "chirp",
+	"hello", "love", "pass", "login", "user", "test", "guest", "root",
+	"computer", "internet", "flower", "cookie", "orange", "banana", "purple", "silver",
+	"thomas", "daniel", "matthew", "robert", "jennifer", "ashley", "nicole", "hannah",
+	"this", "that", "mypassword", "changeme", "default", "access", "money", "family",
+}
+
+var commonWordRank = func() map[string]int {
+	ranks := make(map[string]int, len(commonWords))
+	for i, w := range commonWords {
+		ranks[w] = i + 1
+	}
+	return ranks
+}()
+
+var leet = strings.NewReplacer("4", "a", "@", "a", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t")
+
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+func EstimateEntropy(password string) float64 {	//Rough guessability in bits, in the spirit of zxcvbn. The cheapest way to build the password from brute force characters, common words, repeats, sequences and keyboard runs wins
+	runes := []rune(password)
+	n := len(runes)
+	if n == 0 {
+		return 0
+	}
+	lower := []rune(strings.ToLower(password))
+	charBits := math.Log2(float64(charsetSize(runes)))
+
+	best := make([]float64, n+1)	//best[i] is the cheapest cost of runes[:i]
+	for i := 1; i <= n; i++ {
+		best[i] = best[i-1] + charBits
+		starts := []int{}
+		if run := runStart(lower, i); run < i-maxPatternLength {
+			starts = append(starts, run)
+		}
+		for start := max(0, i-maxPatternLength); start < i; start++ {
+			starts = append(starts, start)
+		}
+		for _, start := range starts {
+			if cost, ok := patternCost(runes[start:i], lower[start:i]); ok && best[start]+cost < best[i] {
+				best[i] = best[start] + cost
+			}
+		}
+	}
+	return best[n]
+}
+
+func runStart(lower []rune, end int) int {	//Earliest start for which lower[start:end] is a single repeated character or a sequence
+	same := end - 1
+	for same > 0 && lower[same-1] == lower[end-1] {
+		same--
+	}
+	seq := end - 1
+	if end >= 2 {
+		if step := lower[end-1] - lower[end-2]; step == 1 || step == -1 {
+			seq = end - 2
+			for seq > 0 && lower[seq]-lower[seq-1] == step {
+				seq--
+			}
+		}
+	}
+	return min(same, seq)
+}
+
+func patternCost(original, lower []rune) (float64, bool) {	//Bits needed to guess a segment if it matches a known pattern
+	length := len(lower)
+	if length < 3 {
+		return 0, false
+	}
+	s := string(lower)
+	cost, matched := math.Inf(1), false
+
+	for _, word := range []string{s, leet.Replace(s)} {
+		if rank, ok := commonWordRank[word]; ok {
+			bits := math.Log2(float64(rank) + 1)
+			if string(original) != s {	//Capitalisation adds a little
+				bits++
+			}
+			if word != s {	//As does leet speak
+				bits++
+			}
+			cost, matched = math.Min(cost, bits), true
+		}
+	}
+
+	if allSame(lower) {
+		cost, matched = math.Min(cost, math.Log2(float64(charsetSize(original)))+math.Log2(float64(length))), true
+	}
+	if isSequence(lower) {
+		cost, matched = math.Min(cost, math.Log2(36)+math.Log2(float64(length))), true
+	}
+	if length >= 4 && isKeyboardRun(s) {
+		cost, matched = math.Min(cost, math.Log2(47)+math.Log2(float64(length))), true
+	}
+	return cost, matched
+}
+
+func charsetSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	size := 0
+	for _, class := range []struct {
+		present bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			size += class.size
+		}
+	}
+	return size
+}
+
+func allSame(runes []rune) bool {
+	for _, r := range runes[1:] {
+		if r != runes[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func isSequence(runes []rune) bool {	//abc, 987 and the like
+	step := runes[1] - runes[0]
+	if step != 1 && step != -1 {
+		return false
+	}
+	for i := 2; i < len(runes); i++ {
+		if runes[i]-runes[i-1] != step {
+			return false
+		}
+	}
+	return true
+}
+
+func isKeyboardRun(s string) bool {	//Straight runs along a QWERTY row, either direction
+	for _, row := range keyboardRows {
+		if strings.Contains(row, s) || strings.Contains(reverse(row), s) {
+			return true
+		}
+	}
+	return false
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+type BreachedList struct {	//SHA-1 digests of known breached passwords, kept sorted for binary search
+	hashes [][sha1.Size]byte
+}
+
+func NewBreachedList(passwords ...string) *BreachedList {	//Builds a list from plain passwords, mostly for tests
+	list := &BreachedList{}
+	for _, p := range passwords {
+		list.hashes = append(list.hashes, sha1.Sum([]byte(p)))
+	}
+	list.sort()
+	return list
+}
+
+func LoadBreachedList(path string) (*BreachedList, error) {	//Reads a file of hex SHA-1 digests, one per line, as in the Pwned Passwords downloads. Anything after a colon on a line, such as a count, is ignored
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := &BreachedList{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if i := bytes.IndexByte(text, ':'); i >= 0 {
+			text = text[:i]
+		}
+		if len(text) == 0 {
+			continue
+		}
+		var digest [sha1.Size]byte
+		if len(text) != hex.EncodedLen(sha1.Size) {
+			return nil, fmt.Errorf("%s line %d: not a SHA-1 digest", path, line)
+		}
+		if _, err := hex.Decode(digest[:], text); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		list.hashes = append(list.hashes, digest)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	list.sort()	//Cheap when the file is already sorted, and saves trusting that it is
+	return list, nil
+}
+
+func (l *BreachedList) Len() int {
+	return len(l.hashes)
+}
+
+func (l *BreachedList) Contains(password string) bool {
+	digest := sha1.Sum([]byte(password))
+	i := sort.Search(len(l.hashes), func(i int) bool {
+		return bytes.Compare(l.hashes[i][:], digest[:]) >= 0
+	})
+	return i < len(l.hashes) && l.hashes[i] == digest
+}
+
+func (l *BreachedList) sort() {
+	sort.Slice(l.hashes, func(i, j int) bool {
+		return bytes.Compare(l.hashes[i][:], l.hashes[j][:]) < 0
+	})
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+)
+
+const (
+	ScopeChirpsRead = "chirps:read"
+	ScopeChirpsWrite = "chirps:write"
+	ScopeProfileRead = "profile:read"
+	ScopeProfileWrite = "profile:write"
+)
+
+var Scopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileRead, ScopeProfileWrite}	//Every scope a personal access token can be granted
+
+const personalAccessTokenPrefix = "chirpy_pat_"	//Lets tokens be told apart from JWTs and spotted by secret scanners
+
+func MakePersonalAccessToken() (string, error) {	//Creates a random token for scripts and bots, shown to the user once
+	key, err := MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	return personalAccessTokenPrefix + key, nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, personalAccessTokenPrefix)
+}
+
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 )
 
@@ -13,4 +14,9 @@ func MakeRefreshToken() (string, error) {	//Creates a random 256-bit (32-byte) s
 	}
 	tokenString := hex.EncodeToString(key)
 	return tokenString, nil
-}
\ No newline at end of file
+}
+
+func HashToken(token string) string {	//Returns the SHA-256 digest a token is stored and looked up by, so the raw token never reaches the database
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
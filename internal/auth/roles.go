@@ -0,0 +1,26 @@
+package auth
+
+const (
+	RoleUser = "user"
+	RoleModerator = "moderator"
+	RoleAdmin = "admin"
+)
+
+var roleRanks = map[string]int{	//Each role can do everything the roles ranked below it can
+	RoleUser: 1,
+	RoleModerator: 2,
+	RoleAdmin: 3,
+}
+
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+func HasRole(role, required string) bool {	//Reports whether role is at least as privileged as required
+	have, ok := roleRanks[role]
+	if !ok {
+		return false
+	}
+	return have >= roleRanks[required]
+}
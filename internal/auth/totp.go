@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod = 30	//Seconds each code is valid for, per RFC 6238
+	totpDigits = 6
+	totpSkew = 1	//Steps either side of now that are still accepted, to allow for clock drift
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateTOTPSecret() (string, error) {	//Creates a random 160-bit secret, base32 encoded for authenticator apps
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(key), nil
+}
+
+func TOTPURI(secret, issuer, account string) string {	//Builds the otpauth:// URI authenticator apps read from a QR code
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+func TOTPCode(secret string, t time.Time) (string, error) {	//Returns the code for the time step containing t
+	return totpCodeAt(secret, t.Unix()/totpPeriod)
+}
+
+func ValidateTOTP(secret, code string, t time.Time, lastStep int64) (int64, error) {	//Checks a code against the steps around t, returning the matched step so it can't be used twice
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, fmt.Errorf("invalid code")
+	}
+	now := t.Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= lastStep {	//Codes at or before the last accepted step were already used
+			continue
+		}
+		want, err := totpCodeAt(secret, step)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid code")
+}
+
+func totpCodeAt(secret string, step int64) (string, error) {	//HOTP (RFC 4226) over the time step counter
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("error decoding secret: %w", err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f	//Dynamic truncation
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+func GenerateRecoveryCodes(n int) ([]string, error) {	//Creates n single-use codes in the form xxxxxx-xxxxxx
+	codes := make([]string, n)
+	for i := range codes {
+		key := make([]byte, 8)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(totpEncoding.EncodeToString(key))[:12]
+		codes[i] = s[:6] + "-" + s[6:]
+	}
+	return codes, nil
+}
+
+func HashRecoveryCode(code string) string {	//Normalises a recovery code as typed by a user before hashing it
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+	return HashToken(code)
+}
@@ -0,0 +1,132 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"	//Registers decoders for image.Decode
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	MaxUploadBytes = 5 << 20
+	maxSide = 4096	//Caps the decoded size, a small file can still claim huge dimensions
+)
+
+var (
+	ErrUnsupportedType = errors.New("avatar must be a JPEG, PNG or GIF image")
+	ErrTooLarge = fmt.Errorf("avatar must be at most %dx%d pixels", maxSide, maxSide)
+)
+
+type Size struct {
+	Name string
+	Pixels int	//Width and height, thumbnails are square
+}
+
+var Sizes = []Size{	//Largest first, each is resized from the original
+	{"large", 256},
+	{"medium", 128},
+	{"small", 48},
+}
+
+type Thumbnail struct {
+	Size Size
+	PNG []byte
+}
+
+var allowedTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+func Sniff(data []byte) (string, error) {	//Works out the image type from its content, ignoring whatever the client claimed
+	contentType := http.DetectContentType(data)
+	if !allowedTypes[contentType] {
+		return "", ErrUnsupportedType
+	}
+	return contentType, nil
+}
+
+func Process(data []byte) ([]Thumbnail, error) {	//Checks an upload and renders it at every size in Sizes, cropped square from the centre
+	if _, err := Sniff(data); err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if config.Width > maxSide || config.Height > maxSide {
+		return nil, ErrTooLarge
+	}
+	if config.Width == 0 || config.Height == 0 {
+		return nil, ErrUnsupportedType
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding avatar: %w", err)
+	}
+
+	square := toRGBA(img, centerSquare(img.Bounds()))	//Re-encoding also drops any metadata, such as GPS tags, that came with the upload
+	thumbs := make([]Thumbnail, 0, len(Sizes))
+	for _, size := range Sizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, Resize(square, size.Pixels)); err != nil {
+			return nil, fmt.Errorf("error encoding avatar: %w", err)
+		}
+		thumbs = append(thumbs, Thumbnail{Size: size, PNG: buf.Bytes()})
+	}
+	return thumbs, nil
+}
+
+func centerSquare(r image.Rectangle) image.Rectangle {
+	side := min(r.Dx(), r.Dy())
+	x := r.Min.X + (r.Dx()-side)/2
+	y := r.Min.Y + (r.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+func toRGBA(img image.Image, r image.Rectangle) *image.RGBA {	//Copies r out of img so pixels can be read straight from Pix
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
+
+func Resize(src *image.RGBA, side int) *image.RGBA {	//Scales a square image to side x side, averaging every source pixel under each output pixel. Enlarging repeats pixels instead
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	for dy := 0; dy < side; dy++ {
+		y0, y1 := span(dy, side, h)
+		for dx := 0; dx < side; dx++ {
+			x0, x1 := span(dx, side, w)
+			var r, g, bl, a, n uint32
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride:]
+				for x := x0; x < x1; x++ {
+					p := row[x*4 : x*4+4]
+					r += uint32(p[0])
+					g += uint32(p[1])
+					bl += uint32(p[2])
+					a += uint32(p[3])
+					n++
+				}
+			}
+			o := dst.PixOffset(dx, dy)
+			dst.Pix[o] = uint8((r + n/2) / n)
+			dst.Pix[o+1] = uint8((g + n/2) / n)
+			dst.Pix[o+2] = uint8((bl + n/2) / n)
+			dst.Pix[o+3] = uint8((a + n/2) / n)
+		}
+	}
+	return dst
+}
+
+func span(i, out, in int) (int, int) {	//Source pixels [start, end) covered by output pixel i, always at least one
+	start := i * in / out
+	end := (i + 1) * in / out
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
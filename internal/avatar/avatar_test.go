@@ -0,0 +1,91 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcess(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 300, 200))	//Left half red, right half blue, cropping to the centre keeps both
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 300; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= 150 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+
+	thumbs, err := Process(encodePNG(t, src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thumbs) != len(Sizes) {
+		t.Fatalf("got %d thumbnails, wanted %d", len(thumbs), len(Sizes))
+	}
+	for _, thumb := range thumbs {
+		img, err := png.Decode(bytes.NewReader(thumb.PNG))
+		if err != nil {
+			t.Fatal(err)
+		}
+		side := thumb.Size.Pixels
+		if b := img.Bounds(); b.Dx() != side || b.Dy() != side {
+			t.Errorf("%s: got %v, wanted %dx%d", thumb.Size.Name, b, side, side)
+		}
+		if r, _, b, _ := img.At(0, side/2).RGBA(); r>>8 != 255 || b != 0 {
+			t.Errorf("%s: left edge should be red", thumb.Size.Name)
+		}
+		if r, _, b, _ := img.At(side-1, side/2).RGBA(); r != 0 || b>>8 != 255 {
+			t.Errorf("%s: right edge should be blue", thumb.Size.Name)
+		}
+	}
+}
+
+func TestProcessAcceptsJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 20, 20)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Process(buf.Bytes()); err != nil {	//Smaller than the thumbnails, so they are enlarged
+		t.Fatal(err)
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	if _, err := Process([]byte("<svg xmlns='http://www.w3.org/2000/svg'></svg>")); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("svg: got %v, wanted ErrUnsupportedType", err)
+	}
+	if _, err := Process([]byte("\x89PNG\r\n\x1a\nnot really")); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("broken png: got %v, wanted ErrUnsupportedType", err)
+	}
+	huge := encodePNG(t, image.NewGray(image.Rect(0, 0, maxSide+1, 1)))
+	if _, err := Process(huge); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("huge: got %v, wanted ErrTooLarge", err)
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src.Set(0, 0, color.RGBA{200, 0, 0, 255})
+	src.Set(1, 0, color.RGBA{0, 0, 0, 255})
+	src.Set(0, 1, color.RGBA{0, 0, 0, 255})
+	src.Set(1, 1, color.RGBA{0, 0, 0, 255})
+	if got := Resize(src, 1).RGBAAt(0, 0); got != (color.RGBA{50, 0, 0, 255}) {
+		t.Errorf("got %v, wanted the average", got)
+	}
+}
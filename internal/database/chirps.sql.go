@@ -53,8 +53,10 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
 }
 
 const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-ORDER BY created_at ASC
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deleted_at IS NULL
+ORDER BY chirps.created_at ASC
 `
 
 func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
@@ -87,9 +89,11 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getChirpsFromAuthor = `-- name: GetChirpsFromAuthor :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.user_id = $1
+AND users.deleted_at IS NULL
+ORDER BY chirps.created_at ASC
 `
 
 func (q *Queries) GetChirpsFromAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
@@ -122,8 +126,10 @@ func (q *Queries) GetChirpsFromAuthor(ctx context.Context, userID uuid.UUID) ([]
 }
 
 const getSingleChirp = `-- name: GetSingleChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-WHERE id = $1
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1
+AND users.deleted_at IS NULL
 `
 
 func (q *Queries) GetSingleChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -7,10 +7,42 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
 )
 
+const banUser = `-- name: BanUser :execrows
+UPDATE users
+SET banned_at = NOW(), token_version = token_version + 1, updated_at = NOW()
+WHERE id = $1
+AND banned_at IS NULL
+`
+
+func (q *Queries) BanUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, banUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const bootstrapAdmin = `-- name: BootstrapAdmin :execrows
+UPDATE users
+SET role = 'admin', token_version = token_version + 1, updated_at = NOW()
+WHERE email = $1
+AND email_verified_at IS NOT NULL
+AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin')
+`
+
+func (q *Queries) BootstrapAdmin(ctx context.Context, email string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, bootstrapAdmin, email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const clearUsers = `-- name: ClearUsers :exec
 DELETE FROM users
 `
@@ -21,16 +53,15 @@ func (q *Queries) ClearUsers(ctx context.Context) error {
 }
 
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password, is_chirpy_red)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password)
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
-    $3,
-    false
+    $3
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, role, token_version, banned_at, deleted_at, handle, display_name, bio, location, website, avatar_key
 `
 
 type CreateUserParams struct {
@@ -48,13 +79,53 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.BannedAt,
+		&i.DeletedAt,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Location,
+		&i.Website,
+		&i.AvatarKey,
 	)
 	return i, err
 }
 
+const disableTOTP = `-- name: DisableTOTP :exec
+UPDATE users
+SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) DisableTOTP(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, disableTOTP, id)
+	return err
+}
+
+const enableTOTP = `-- name: EnableTOTP :exec
+UPDATE users
+SET totp_enabled_at = NOW(), totp_last_step = $1, updated_at = NOW()
+WHERE id = $2
+`
+
+type EnableTOTPParams struct {
+	TotpLastStep int64
+	ID           uuid.UUID
+}
+
+func (q *Queries) EnableTOTP(ctx context.Context, arg EnableTOTPParams) error {
+	_, err := q.db.ExecContext(ctx, enableTOTP, arg.TotpLastStep, arg.ID)
+	return err
+}
+
 const getUserFromEmail = `-- name: GetUserFromEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users
+SELECT id, created_at, updated_at, email, hashed_password, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, role, token_version, banned_at, deleted_at, handle, display_name, bio, location, website, avatar_key FROM users
 WHERE email = $1
 `
 
@@ -67,13 +138,58 @@ func (q *Queries) GetUserFromEmail(ctx context.Context, email string) (User, err
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.BannedAt,
+		&i.DeletedAt,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Location,
+		&i.Website,
+		&i.AvatarKey,
+	)
+	return i, err
+}
+
+const getUserFromHandle = `-- name: GetUserFromHandle :one
+SELECT id, created_at, updated_at, email, hashed_password, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, role, token_version, banned_at, deleted_at, handle, display_name, bio, location, website, avatar_key FROM users
+WHERE lower(handle) = lower($1)
+`
+
+func (q *Queries) GetUserFromHandle(ctx context.Context, lower string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserFromHandle, lower)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.BannedAt,
+		&i.DeletedAt,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Location,
+		&i.Website,
+		&i.AvatarKey,
 	)
 	return i, err
 }
 
 const getUserFromID = `-- name: GetUserFromID :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users
+SELECT id, created_at, updated_at, email, hashed_password, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, role, token_version, banned_at, deleted_at, handle, display_name, bio, location, website, avatar_key FROM users
 WHERE id = $1
 `
 
@@ -86,25 +202,273 @@ func (q *Queries) GetUserFromID(ctx context.Context, id uuid.UUID) (User, error)
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.BannedAt,
+		&i.DeletedAt,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Location,
+		&i.Website,
+		&i.AvatarKey,
+	)
+	return i, err
+}
+
+const getUserTokenState = `-- name: GetUserTokenState :one
+SELECT token_version, banned_at, role FROM users
+WHERE id = $1
+`
+
+type GetUserTokenStateRow struct {
+	TokenVersion int32
+	BannedAt     sql.NullTime
+	Role         string
+}
+
+func (q *Queries) GetUserTokenState(ctx context.Context, id uuid.UUID) (GetUserTokenStateRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserTokenState, id)
+	var i GetUserTokenStateRow
+	err := row.Scan(
+		&i.TokenVersion,
+		&i.BannedAt,
+		&i.Role,
 	)
 	return i, err
 }
 
-const updateChirpyRed = `-- name: UpdateChirpyRed :exec
+const incrementTokenVersion = `-- name: IncrementTokenVersion :exec
 UPDATE users
-SET is_chirpy_red = true, updated_at = NOW()
+SET token_version = token_version + 1, updated_at = NOW()
 WHERE id = $1
 `
 
-func (q *Queries) UpdateChirpyRed(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, updateChirpyRed, id)
+func (q *Queries) IncrementTokenVersion(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, incrementTokenVersion, id)
 	return err
 }
 
+const purgeDeletedUsers = `-- name: PurgeDeletedUsers :many
+DELETE FROM users
+WHERE deleted_at < $1
+RETURNING id, avatar_key
+`
+
+type PurgeDeletedUsersRow struct {
+	ID        uuid.UUID
+	AvatarKey sql.NullString
+}
+
+func (q *Queries) PurgeDeletedUsers(ctx context.Context, deletedAt sql.NullTime) ([]PurgeDeletedUsersRow, error) {
+	rows, err := q.db.QueryContext(ctx, purgeDeletedUsers, deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PurgeDeletedUsersRow
+	for rows.Next() {
+		var i PurgeDeletedUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.AvatarKey,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const restoreUser = `-- name: RestoreUser :execrows
+UPDATE users
+SET deleted_at = NULL, updated_at = NOW()
+WHERE id = $1
+AND deleted_at IS NOT NULL
+`
+
+func (q *Queries) RestoreUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, restoreUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setAvatar = `-- name: SetAvatar :exec
+UPDATE users
+SET avatar_key = $1, updated_at = NOW()
+WHERE id = $2
+`
+
+type SetAvatarParams struct {
+	AvatarKey sql.NullString
+	ID        uuid.UUID
+}
+
+func (q *Queries) SetAvatar(ctx context.Context, arg SetAvatarParams) error {
+	_, err := q.db.ExecContext(ctx, setAvatar, arg.AvatarKey, arg.ID)
+	return err
+}
+
+const setTOTPSecret = `-- name: SetTOTPSecret :exec
+UPDATE users
+SET totp_secret = $1, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+WHERE id = $2
+`
+
+type SetTOTPSecretParams struct {
+	TotpSecret sql.NullString
+	ID         uuid.UUID
+}
+
+func (q *Queries) SetTOTPSecret(ctx context.Context, arg SetTOTPSecretParams) error {
+	_, err := q.db.ExecContext(ctx, setTOTPSecret, arg.TotpSecret, arg.ID)
+	return err
+}
+
+const setUserRole = `-- name: SetUserRole :execrows
+UPDATE users
+SET role = $1, token_version = token_version + 1, updated_at = NOW()
+WHERE id = $2
+`
+
+type SetUserRoleParams struct {
+	Role string
+	ID   uuid.UUID
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserRole, arg.Role, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const softDeleteUser = `-- name: SoftDeleteUser :execrows
+UPDATE users
+SET deleted_at = NOW(), token_version = token_version + 1, updated_at = NOW()
+WHERE id = $1
+AND deleted_at IS NULL
+`
+
+func (q *Queries) SoftDeleteUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, softDeleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const unbanUser = `-- name: UnbanUser :execrows
+UPDATE users
+SET banned_at = NULL, updated_at = NOW()
+WHERE id = $1
+AND banned_at IS NOT NULL
+`
+
+func (q *Queries) UnbanUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, unbanUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updatePassword = `-- name: UpdatePassword :exec
+UPDATE users
+SET hashed_password = $1, updated_at = NOW()
+WHERE id = $2
+`
+
+type UpdatePasswordParams struct {
+	HashedPassword string
+	ID             uuid.UUID
+}
+
+func (q *Queries) UpdatePassword(ctx context.Context, arg UpdatePasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updatePassword, arg.HashedPassword, arg.ID)
+	return err
+}
+
+const updateProfile = `-- name: UpdateProfile :one
+UPDATE users
+SET handle = $1, display_name = $2, bio = $3, location = $4, website = $5, updated_at = NOW()
+WHERE id = $6
+RETURNING id, created_at, updated_at, email, hashed_password, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, role, token_version, banned_at, deleted_at, handle, display_name, bio, location, website, avatar_key
+`
+
+type UpdateProfileParams struct {
+	Handle      sql.NullString
+	DisplayName string
+	Bio         string
+	Location    string
+	Website     string
+	ID          uuid.UUID
+}
+
+func (q *Queries) UpdateProfile(ctx context.Context, arg UpdateProfileParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateProfile, arg.Handle, arg.DisplayName, arg.Bio, arg.Location, arg.Website, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.BannedAt,
+		&i.DeletedAt,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Location,
+		&i.Website,
+		&i.AvatarKey,
+	)
+	return i, err
+}
+
+const updateTOTPLastStep = `-- name: UpdateTOTPLastStep :execrows
+UPDATE users
+SET totp_last_step = $1
+WHERE id = $2
+AND totp_last_step < $1
+`
+
+type UpdateTOTPLastStepParams struct {
+	TotpLastStep int64
+	ID           uuid.UUID
+}
+
+func (q *Queries) UpdateTOTPLastStep(ctx context.Context, arg UpdateTOTPLastStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateTOTPLastStep, arg.TotpLastStep, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const updateUserInfo = `-- name: UpdateUserInfo :exec
 UPDATE users
-SET email = $1, hashed_password = $2, updated_at = NOW()
+SET email = $1, hashed_password = $2, updated_at = NOW(),
+email_verified_at = CASE WHEN email = $1 THEN email_verified_at ELSE NULL END
 WHERE id = $3
 `
 
@@ -118,3 +482,24 @@ func (q *Queries) UpdateUserInfo(ctx context.Context, arg UpdateUserInfoParams)
 	_, err := q.db.ExecContext(ctx, updateUserInfo, arg.Email, arg.HashedPassword, arg.ID)
 	return err
 }
+
+const verifyUserEmail = `-- name: VerifyUserEmail :execrows
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1
+AND email = $2
+AND email_verified_at IS NULL
+`
+
+type VerifyUserEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, verifyUserEmail, arg.ID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,168 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type mockProvider struct {	//A minimal OIDC provider that issues an ID token for one known code
+	server *httptest.Server
+	key *rsa.PrivateKey
+	code string
+	challenge string	//PKCE challenge sent with the authorization request
+	nonce string
+	audience string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key, code: "good-code", audience: "chirpy-client"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer": m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint": m.server.URL + "/token",
+			"jwks_uri": m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock",
+			"use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		if r.PostForm.Get("code") != m.code || CodeChallenge(r.PostForm.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss": m.server.URL,
+			"sub": "external-123",
+			"aud": m.audience,
+			"exp": time.Now().Add(time.Hour).Unix(),
+			"iat": time.Now().Unix(),
+			"nonce": m.nonce,
+			"email": "sso@example.com",
+			"email_verified": true,
+		})
+		token.Header["kid"] = "mock"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": signed})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockProvider) authorize(t *testing.T, authURL string) {	//Plays the part of the browser and provider login page
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected S256 PKCE, got %q", q.Get("code_challenge_method"))
+	}
+	m.challenge = q.Get("code_challenge")
+	m.nonce = q.Get("nonce")
+}
+
+func TestLoginFlow(t *testing.T) {
+	mock := newMockProvider(t)
+	provider := NewProvider(Config{
+		Issuer: mock.server.URL,
+		ClientID: "chirpy-client",
+		ClientSecret: "secret",
+		RedirectURL: "http://localhost:8080/api/login/oidc/callback",
+	})
+	ctx := context.Background()
+
+	verifier, _ := RandomString()
+	nonce, _ := RandomString()
+	authURL, err := provider.AuthCodeURL(ctx, "state", nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.authorize(t, authURL)
+
+	if _, err := provider.Exchange(ctx, mock.code, "wrong-verifier", nonce); err == nil {
+		t.Error("exchange with the wrong PKCE verifier should fail")
+	}
+
+	idToken, err := provider.Exchange(ctx, mock.code, verifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idToken.Subject != "external-123" || idToken.Email != "sso@example.com" || !idToken.EmailVerified || idToken.Issuer != mock.server.URL {
+		t.Errorf("unexpected id token claims: %+v", idToken)
+	}
+
+	if _, err := provider.Exchange(ctx, mock.code, verifier, "other-nonce"); err == nil {
+		t.Error("exchange with a different nonce should fail")
+	}
+}
+
+func TestRejectsWrongAudience(t *testing.T) {
+	mock := newMockProvider(t)
+	mock.audience = "someone-else"
+	provider := NewProvider(Config{Issuer: mock.server.URL, ClientID: "chirpy-client"})
+	ctx := context.Background()
+
+	verifier, _ := RandomString()
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.authorize(t, authURL)
+
+	if _, err := provider.Exchange(ctx, mock.code, verifier, "nonce"); err == nil {
+		t.Error("id token for another client should be rejected")
+	}
+}
+
+func TestStateStore(t *testing.T) {
+	store := NewStateStore()
+	store.Put("s1", FlowState{Nonce: "n", ExpiresAt: time.Now().Add(time.Minute)})
+	store.Put("s2", FlowState{Nonce: "n", ExpiresAt: time.Now().Add(-time.Minute)})
+
+	if _, ok := store.Take("s1"); !ok {
+		t.Error("expected s1 to be found")
+	}
+	if _, ok := store.Take("s1"); ok {
+		t.Error("state should only be usable once")
+	}
+	if _, ok := store.Take("s2"); ok {
+		t.Error("expired state should not be returned")
+	}
+}
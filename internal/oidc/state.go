@@ -0,0 +1,48 @@
+package oidc
+
+import (
+	"sync"
+	"time"
+)
+
+type FlowState struct {	//What we need to remember between sending a browser to the provider and its callback
+	Nonce string
+	CodeVerifier string
+	UseCookies bool	//The browser asked for a cookie session rather than tokens in the body
+	ExpiresAt time.Time
+}
+
+type StateStore struct {	//Holds in-flight logins keyed by their state parameter, each one usable once
+	mu sync.Mutex
+	flows map[string]FlowState
+}
+
+func NewStateStore() *StateStore {
+	return &StateStore{flows: map[string]FlowState{}}
+}
+
+func (s *StateStore) Put(state string, flow FlowState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, f := range s.flows {	//Drop abandoned logins
+		if now.After(f.ExpiresAt) {
+			delete(s.flows, k)
+		}
+	}
+	s.flows[state] = flow
+}
+
+func (s *StateStore) Take(state string) (FlowState, bool) {	//Returns and removes the flow for state, if it exists and hasn't expired
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flow, ok := s.flows[state]
+	if !ok {
+		return FlowState{}, false
+	}
+	delete(s.flows, state)
+	if time.Now().After(flow.ExpiresAt) {
+		return FlowState{}, false
+	}
+	return flow, true
+}
@@ -0,0 +1,122 @@
+package profile
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MinHandleLength = 3
+	MaxHandleLength = 30	//Shorter than a UUID, so a handle can never be mistaken for a user id
+	MaxDisplayName = 50
+	MaxBio = 160
+	MaxLocation = 30
+	MaxWebsite = 100
+)
+
+var reservedHandles = map[string]bool{	//Names that could pass for staff, the service itself, or a route. Every literal segment after /api/users/ must be here, or a user with that handle could never be fetched by it
+	"admin": true, "administrator": true, "root": true, "system": true, "support": true, "help": true,
+	"staff": true, "moderator": true, "mod": true, "security": true, "abuse": true, "postmaster": true,
+	"api": true, "app": true, "assets": true, "login": true, "logout": true, "signup": true, "register": true,
+	"settings": true, "me": true, "verify": true, "users": true, "user": true, "chirps": true, "about": true, "terms": true,
+	"privacy": true, "null": true, "undefined": true, "everyone": true, "here": true,
+}
+
+type FieldError struct {	//A profile field that failed validation, Field is its JSON name
+	Field string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+type Fields struct {
+	Handle string	//Empty for no handle. Case is kept for display but ignored when checking uniqueness
+	DisplayName string
+	Bio string
+	Location string
+	Website string
+}
+
+func NormalizeHandle(handle string) string {	//Trims spaces and an optional leading @
+	return strings.TrimPrefix(strings.TrimSpace(handle), "@")
+}
+
+func (f Fields) Normalize() Fields {
+	return Fields{
+		Handle: NormalizeHandle(f.Handle),
+		DisplayName: strings.TrimSpace(f.DisplayName),
+		Bio: strings.TrimSpace(f.Bio),
+		Location: strings.TrimSpace(f.Location),
+		Website: strings.TrimSpace(f.Website),
+	}
+}
+
+func (f Fields) Validate() error {	//Returns a *FieldError for the first invalid field. Call on normalized fields
+	if f.Handle != "" {
+		if err := ValidateHandle(f.Handle); err != nil {
+			return err
+		}
+	}
+	for _, field := range []struct {
+		name, value string
+		max int
+		multiline bool
+	}{
+		{"display_name", f.DisplayName, MaxDisplayName, false},
+		{"bio", f.Bio, MaxBio, true},
+		{"location", f.Location, MaxLocation, false},
+	} {
+		if err := checkText(field.name, field.value, field.max, field.multiline); err != nil {
+			return err
+		}
+	}
+	if f.Website != "" {
+		if len(f.Website) > MaxWebsite {
+			return &FieldError{Field: "website", Message: fmt.Sprintf("Website must be at most %d characters", MaxWebsite)}
+		}
+		u, err := url.Parse(f.Website)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+			return &FieldError{Field: "website", Message: "Website must be an http or https URL"}
+		}
+	}
+	return nil
+}
+
+func ValidateHandle(handle string) error {	//Letters, digits and underscores, starting with a letter, and not reserved
+	if len(handle) < MinHandleLength || len(handle) > MaxHandleLength {
+		return &FieldError{Field: "handle", Message: fmt.Sprintf("Handle must be %d to %d characters", MinHandleLength, MaxHandleLength)}
+	}
+	for i, r := range handle {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '_'):
+		default:
+			return &FieldError{Field: "handle", Message: "Handle must start with a letter and use only letters, digits and underscores"}
+		}
+	}
+	lower := strings.ToLower(handle)
+	if reservedHandles[lower] || strings.HasPrefix(lower, "chirpy") {
+		return &FieldError{Field: "handle", Message: "That handle is reserved"}
+	}
+	return nil
+}
+
+func checkText(field, value string, max int, multiline bool) error {
+	if !utf8.ValidString(value) {
+		return &FieldError{Field: field, Message: fmt.Sprintf("%s must be valid UTF-8", field)}
+	}
+	if utf8.RuneCountInString(value) > max {
+		return &FieldError{Field: field, Message: fmt.Sprintf("%s must be at most %d characters", field, max)}
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) && !(multiline && r == '\n') {
+			return &FieldError{Field: field, Message: fmt.Sprintf("%s must not contain control characters", field)}
+		}
+	}
+	return nil
+}
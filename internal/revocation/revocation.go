@@ -0,0 +1,156 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrRevoked = errors.New("token has been revoked")
+	ErrBanned = errors.New("account is banned")
+)
+
+type UserState struct {
+	TokenVersion int32	//Access tokens issued with an older version are void
+	Banned bool
+	Role string	//Lets impersonation tokens be cut off once their admin is demoted
+}
+
+type Store interface {	//Where revocations are kept, shared by every instance of the server
+	UserState(ctx context.Context, userID uuid.UUID) (UserState, error)
+	IsDenied(ctx context.Context, id uuid.UUID) (bool, error)
+	Deny(ctx context.Context, id uuid.UUID, until time.Time) error
+}
+
+type Checker struct {	//Checks access tokens against a Store, caching answers for a short TTL so most requests skip the database
+	store Store
+	ttl time.Duration
+	mu sync.Mutex
+	users map[uuid.UUID]cachedUser
+	denied map[uuid.UUID]cachedDenial
+	nextSweep time.Time
+	now func() time.Time
+}
+
+type cachedUser struct {
+	state UserState
+	expires time.Time
+}
+
+type cachedDenial struct {
+	denied bool
+	expires time.Time
+}
+
+func New(store Store, ttl time.Duration) *Checker {	//ttl bounds how long a revocation made by another instance can go unnoticed
+	return &Checker{
+		store: store,
+		ttl: ttl,
+		users: map[uuid.UUID]cachedUser{},
+		denied: map[uuid.UUID]cachedDenial{},
+		now: time.Now,
+	}
+}
+
+func (c *Checker) Check(ctx context.Context, userID uuid.UUID, version int32, ids ...uuid.UUID) error {	//Returns ErrRevoked or ErrBanned if a token is no longer good. ids are the token's jti and session, uuid.Nil entries are skipped
+	state, err := c.User(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if state.Banned {
+		return ErrBanned
+	}
+	if version != state.TokenVersion {
+		return ErrRevoked
+	}
+	for _, id := range ids {
+		if id == uuid.Nil {
+			continue
+		}
+		denied, err := c.isDenied(ctx, id)
+		if err != nil {
+			return err
+		}
+		if denied {
+			return ErrRevoked
+		}
+	}
+	return nil
+}
+
+func (c *Checker) User(ctx context.Context, userID uuid.UUID) (UserState, error) {	//Returns a user's revocation state, from the cache when fresh
+	now := c.now()
+	c.mu.Lock()
+	cached, ok := c.users[userID]
+	c.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.state, nil
+	}
+
+	state, err := c.store.UserState(ctx, userID)
+	if err != nil {
+		return UserState{}, err
+	}
+	c.mu.Lock()
+	c.sweep(now)
+	c.users[userID] = cachedUser{state: state, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return state, nil
+}
+
+func (c *Checker) isDenied(ctx context.Context, id uuid.UUID) (bool, error) {
+	now := c.now()
+	c.mu.Lock()
+	cached, ok := c.denied[id]
+	c.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.denied, nil
+	}
+
+	denied, err := c.store.IsDenied(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	c.sweep(now)
+	c.denied[id] = cachedDenial{denied: denied, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return denied, nil
+}
+
+func (c *Checker) Deny(ctx context.Context, id uuid.UUID, until time.Time) error {	//Puts a token or session id on the deny-list until every token carrying it has expired
+	if err := c.store.Deny(ctx, id, until); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.denied[id] = cachedDenial{denied: true, expires: until}	//Takes effect on this instance at once
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Checker) Forget(userID uuid.UUID) {	//Drops a cached user after their token version or ban changed, so this instance sees it at once
+	c.mu.Lock()
+	delete(c.users, userID)
+	c.mu.Unlock()
+}
+
+func (c *Checker) sweep(now time.Time) {	//Drops expired cache entries every ttl, called with mu held
+	if now.Before(c.nextSweep) {
+		return
+	}
+	c.nextSweep = now.Add(c.ttl)
+	for id, cached := range c.users {
+		if !now.Before(cached.expires) {
+			delete(c.users, id)
+		}
+	}
+	for id, cached := range c.denied {
+		if !now.Before(cached.expires) {
+			delete(c.denied, id)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type memoryStore struct {
+	users map[uuid.UUID]UserState
+	denied map[uuid.UUID]time.Time
+	lookups int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{users: map[uuid.UUID]UserState{}, denied: map[uuid.UUID]time.Time{}}
+}
+
+func (s *memoryStore) UserState(ctx context.Context, userID uuid.UUID) (UserState, error) {
+	s.lookups++
+	return s.users[userID], nil
+}
+
+func (s *memoryStore) IsDenied(ctx context.Context, id uuid.UUID) (bool, error) {
+	s.lookups++
+	until, ok := s.denied[id]
+	return ok && time.Now().Before(until), nil
+}
+
+func (s *memoryStore) Deny(ctx context.Context, id uuid.UUID, until time.Time) error {
+	s.denied[id] = until
+	return nil
+}
+
+func TestCheckCaches(t *testing.T) {
+	store := newMemoryStore()
+	checker := New(store, time.Minute)
+	now := time.Now()
+	checker.now = func() time.Time { return now }
+	ctx := context.Background()
+	user, jti := uuid.New(), uuid.New()
+
+	for range 5 {
+		if err := checker.Check(ctx, user, 0, jti); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.lookups != 2 {
+		t.Errorf("got %d store lookups, wanted 2", store.lookups)
+	}
+
+	store.users[user] = UserState{TokenVersion: 1}	//Changed by another instance
+	if err := checker.Check(ctx, user, 0, jti); err != nil {
+		t.Errorf("cached state should be used until the ttl passes, got %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if err := checker.Check(ctx, user, 0, jti); !errors.Is(err, ErrRevoked) {
+		t.Errorf("got %v, wanted ErrRevoked", err)
+	}
+	if err := checker.Check(ctx, user, 1, jti); err != nil {
+		t.Errorf("token with the current version rejected: %v", err)
+	}
+}
+
+func TestDenyAndForget(t *testing.T) {
+	store := newMemoryStore()
+	checker := New(store, time.Minute)
+	ctx := context.Background()
+	user, jti, session := uuid.New(), uuid.New(), uuid.New()
+
+	if err := checker.Check(ctx, user, 0, jti, session); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.Deny(ctx, session, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.Check(ctx, user, 0, jti, session); !errors.Is(err, ErrRevoked) {
+		t.Errorf("denied session should take effect at once, got %v", err)
+	}
+	if err := checker.Check(ctx, user, 0, uuid.New(), uuid.Nil); err != nil {
+		t.Errorf("other tokens should be unaffected, got %v", err)
+	}
+
+	store.users[user] = UserState{TokenVersion: 1, Banned: true}
+	checker.Forget(user)
+	if err := checker.Check(ctx, user, 1, uuid.New()); !errors.Is(err, ErrBanned) {
+		t.Errorf("got %v, wanted ErrBanned", err)
+	}
+}
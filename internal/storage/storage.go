@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidKey = errors.New("invalid storage key")
+
+type Store interface {	//Holds uploaded files under slash separated keys, implementations are swapped by config
+	Put(ctx context.Context, key string, r io.Reader) error
+	Delete(ctx context.Context, key string) error	//Deleting a missing key is not an error
+	URL(key string) string	//Where clients can fetch the file from
+}
+
+type Disk struct {	//Stores files in a local directory that is served as static files
+	dir string
+	baseURL string
+}
+
+func NewDisk(dir, baseURL string) (*Disk, error) {	//baseURL is the public URL the directory is served from
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating storage directory: %w", err)
+	}
+	return &Disk{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (d *Disk) path(key string) (string, error) {	//Maps a key to a file under dir, refusing anything that could escape it
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || path.Clean(key) != key || strings.HasPrefix(key, "..") {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(d.dir, filepath.FromSlash(key)), nil
+}
+
+func (d *Disk) Put(ctx context.Context, key string, r io.Reader) error {	//Writes to a temporary file first so readers never see half a file
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("error creating directory for %s: %w", key, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())	//No-op once renamed
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing %s: %w", key, err)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("error saving %s: %w", key, err)
+	}
+	return nil
+}
+
+func (d *Disk) Delete(ctx context.Context, key string) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error deleting %s: %w", key, err)
+	}
+	for dir := filepath.Dir(p); dir != filepath.Clean(d.dir); dir = filepath.Dir(dir) {	//Tidies up directories left empty, stopping at the first that isn't
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (d *Disk) URL(key string) string {
+	return d.baseURL + "/" + key
+}
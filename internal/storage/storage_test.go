@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDisk(t *testing.T) {
+	dir := t.TempDir()
+	d, err := NewDisk(dir, "/app/assets/avatars/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := d.Put(ctx, "user/one/large.png", strings.NewReader("image")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "user", "one", "large.png"))
+	if err != nil || string(got) != "image" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if url := d.URL("user/one/large.png"); url != "/app/assets/avatars/user/one/large.png" {
+		t.Errorf("got URL %s", url)
+	}
+
+	if err := d.Delete(ctx, "user/one/large.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "user")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("empty directories should be removed")
+	}
+	if err := d.Delete(ctx, "user/one/large.png"); err != nil {
+		t.Errorf("deleting a missing key: %s", err)
+	}
+}
+
+func TestDiskRejectsEscapingKeys(t *testing.T) {
+	d, err := NewDisk(t.TempDir(), "/avatars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"", "/etc/passwd", "../outside", "a/../../outside", "a//b", `a\b`, "a/./b"} {
+		if err := d.Put(context.Background(), key, strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("key %q: got %v, wanted ErrInvalidKey", key, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+type Policy struct {
+	FreeAttempts int	//Failures allowed before any delay is imposed
+	BaseDelay time.Duration	//Delay after the first failure past FreeAttempts, doubling with each failure after
+	MaxDelay time.Duration
+	LockoutAfter int	//Failures that lock the key out entirely
+	LockoutDuration time.Duration
+	Window time.Duration	//Failures are forgotten once a key has been quiet this long
+}
+
+type Limiter struct {	//Tracks failed attempts per key, slowing down and then locking out keys that keep failing
+	policy Policy
+	mu sync.Mutex
+	entries map[string]*entry
+	now func() time.Time
+}
+
+type entry struct {
+	failures int
+	lastFailure time.Time
+	blockedUntil time.Time
+}
+
+const sweepThreshold = 10000	//Entry count that triggers dropping stale keys
+
+func New(policy Policy) *Limiter {
+	return &Limiter{
+		policy: policy,
+		entries: map[string]*entry{},
+		now: time.Now,
+	}
+}
+
+func (l *Limiter) Allow(key string) (time.Duration, bool) {	//Reports whether key may attempt now, and if not, how long until it may
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok {
+		return 0, true
+	}
+	now := l.now()
+	if wait := e.blockedUntil.Sub(now); wait > 0 {
+		return wait, false
+	}
+	return 0, true
+}
+
+func (l *Limiter) Fail(key string) {	//Records a failed attempt, extending how long key has to wait
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+
+	e, ok := l.entries[key]
+	if !ok || (now.Sub(e.lastFailure) > l.policy.Window && now.After(e.blockedUntil)) {
+		if len(l.entries) >= sweepThreshold {
+			l.sweep(now)
+		}
+		e = &entry{}
+		l.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+
+	switch {
+	case l.policy.LockoutAfter > 0 && e.failures >= l.policy.LockoutAfter:
+		e.blockedUntil = now.Add(l.policy.LockoutDuration)
+	case e.failures > l.policy.FreeAttempts:
+		delay := l.policy.BaseDelay << (e.failures - l.policy.FreeAttempts - 1)
+		if delay > l.policy.MaxDelay || delay <= 0 {	//Also catches the shift overflowing
+			delay = l.policy.MaxDelay
+		}
+		e.blockedUntil = now.Add(delay)
+	}
+}
+
+func (l *Limiter) Reset(key string) {	//Forgets key's failures, called after a successful attempt
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	for key, e := range l.entries {
+		if now.Sub(e.lastFailure) > l.policy.Window && now.After(e.blockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+}
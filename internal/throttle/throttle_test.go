@@ -0,0 +1,62 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(Policy{
+		FreeAttempts: 2,
+		BaseDelay: time.Second,
+		MaxDelay: 4 * time.Second,
+		LockoutAfter: 6,
+		LockoutDuration: time.Hour,
+		Window: 15 * time.Minute,
+	})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		l.Fail("a")
+		if _, ok := l.Allow("a"); !ok {
+			t.Fatalf("failure %d should still be free", i+1)
+		}
+	}
+
+	wantDelays := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	for _, want := range wantDelays {
+		l.Fail("a")
+		wait, ok := l.Allow("a")
+		if ok || wait != want {
+			t.Errorf("got: %s, %v -- wanted: %s, false", wait, ok, want)
+		}
+		now = now.Add(wait)
+	}
+
+	l.Fail("a")	//Sixth failure locks the key out
+	if wait, ok := l.Allow("a"); ok || wait != time.Hour {
+		t.Errorf("got: %s, %v -- wanted: 1h0m0s, false", wait, ok)
+	}
+	if _, ok := l.Allow("b"); !ok {
+		t.Error("other keys should not be affected")
+	}
+
+	l.Reset("a")
+	if _, ok := l.Allow("a"); !ok {
+		t.Error("reset key should be allowed")
+	}
+}
+
+func TestLimiterForgetsQuietKeys(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(Policy{FreeAttempts: 1, BaseDelay: time.Second, MaxDelay: time.Second, Window: time.Minute})
+	l.now = func() time.Time { return now }
+
+	l.Fail("a")
+	now = now.Add(2 * time.Minute)
+	l.Fail("a")	//Counted as the first failure again since the window passed
+	if _, ok := l.Allow("a"); !ok {
+		t.Error("failures outside the window should be forgotten")
+	}
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	v, err := NewVerifier([]string{"new-secret", "old-secret"}, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return now }
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+
+	ts, sig := Sign("old-secret", now, body)
+	if _, err := v.Verify(body, ts, sig); err != nil {
+		t.Errorf("signature from a secret being rotated out rejected: %s", err)
+	}
+	_, other := Sign("unknown", now, body)
+	if _, err := v.Verify(body, ts, other+","+sig); err != nil {
+		t.Errorf("any matching signature in the header should do: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		body []byte
+		timestamp string
+		signature string
+		want error
+	}{
+		{"missing", body, "", "", ErrMissingSignature},
+		{"tampered body", []byte(`{"event":"user.upgraded"}`), ts, sig, ErrBadSignature},
+		{"wrong secret", body, ts, other, ErrBadSignature},
+		{"malformed", body, ts, "v1=zz", ErrBadSignature},
+	}
+	for _, tc := range tests {
+		if _, err := v.Verify(tc.body, tc.timestamp, tc.signature); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, wanted %v", tc.name, err, tc.want)
+		}
+	}
+
+	oldTs, oldSig := Sign("new-secret", now.Add(-10*time.Minute), body)
+	if _, err := v.Verify(body, oldTs, oldSig); !errors.Is(err, ErrBadTimestamp) {
+		t.Errorf("stale request: got %v, wanted ErrBadTimestamp", err)
+	}
+}
+
+func TestNonce(t *testing.T) {
+	body := []byte(`{}`)
+	if Nonce("1", body) != Nonce("1", body) {
+		t.Error("nonce should be stable for the same delivery")
+	}
+	if Nonce("1", body) == Nonce("2", body) {
+		t.Error("nonce should change with the timestamp")
+	}
+}
+
+func TestNewVerifierNeedsSecret(t *testing.T) {
+	if _, err := NewVerifier([]string{"", " "}, time.Minute); err == nil {
+		t.Error("verifier with no secrets created")
+	}
+}
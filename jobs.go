@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+func (cfg *apiConfig) purgeExpired(interval time.Duration) {	//Deletes rows that only matter until a deadline, such as deny-list entries for tokens that have expired anyway
+	for range time.Tick(interval) {
+		ctx := context.Background()
+		if err := cfg.db.DeleteExpiredDenials(ctx); err != nil {
+			fmt.Printf("Error purging access token deny-list: %s\n", err)
+		}
+		if err := cfg.db.DeleteExpiredMagicLinks(ctx); err != nil {
+			fmt.Printf("Error purging used magic links: %s\n", err)
+		}
+	}
+}
+
+func (cfg *apiConfig) expireSubscriptions(interval time.Duration) {	//Marks subscriptions expired once their paid period has run out
+	for range time.Tick(interval) {
+		expired, err := cfg.db.ExpireLapsedSubscriptions(context.Background())
+		if err != nil {
+			fmt.Printf("Error expiring subscriptions: %s\n", err)
+			continue
+		}
+		if len(expired) > 0 {
+			fmt.Printf("Expired %d lapsed subscriptions\n", len(expired))
+		}
+	}
+}
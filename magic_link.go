@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/database"
+	"github.com/jms-guy/chirpy/internal/mail"
+	"github.com/jms-guy/chirpy/internal/oidc"
+)
+
+const magicLinkCookie = "chirpy_magic"
+
+func (cfg *apiConfig) magicLinkHandler(w http.ResponseWriter, req *http.Request) {	//Emails a single use login link, bound to this browser by a nonce cookie. Always responds the same way so it can't reveal which emails have accounts
+	type httpRequest struct {
+		Email string `json:"email"`
+	}
+
+	request := httpRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, 400, "Invalid JSON payload")
+		return
+	}
+	if !cfg.allowMail(w, req, request.Email) {
+		return
+	}
+
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		respondWithError(w, 500, "Error starting login")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{	//Set whether or not the account exists, for the same reason
+		Name: magicLinkCookie,
+		Value: nonce,
+		Path: "/api/login/magic",
+		MaxAge: int(auth.MagicLinkTTL.Seconds()),
+		HttpOnly: true,
+		Secure: cfg.secureCookies,
+		SameSite: http.SameSiteStrictMode,	//The link opens magic-login.html, whose request to exchange it is same site
+	})
+
+	go cfg.sendMagicLink(request.Email, auth.HashToken(nonce))	//Done after responding so response time doesn't depend on whether the account exists
+
+	respondWithJSON(w, 202, map[string]string{"status": "If an account exists for that email, a login link has been sent"})
+}
+
+func (cfg *apiConfig) sendMagicLink(email, nonceHash string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user, err := cfg.db.GetUserFromEmail(ctx, email)
+	if err != nil || user.BannedAt.Valid {
+		return
+	}
+	if err := cfg.mailMagicLink(ctx, user, nonceHash); err != nil {
+		fmt.Printf("Error sending login link to user %s: %s\n", user.ID, err)
+	}
+}
+
+func (cfg *apiConfig) mailMagicLink(ctx context.Context, user database.User, nonceHash string) error {
+	token, err := auth.MakeMagicLinkJWT(user.ID, user.Email, nonceHash, cfg.keys)
+	if err != nil {
+		return err
+	}
+	link := cfg.baseURL + "/app/magic-login.html?token=" + url.QueryEscape(token)
+	return cfg.mailer.Send(ctx, mail.Message{
+		To: user.Email,
+		Subject: "Your Chirpy login link",
+		Body: fmt.Sprintf("Open the link below in the same browser you asked for it from to log in to Chirpy. It works once, within the next %d minutes:\n\n%s\n\nIf you didn't ask to log in, you can ignore this email.\n", int(auth.MagicLinkTTL.Minutes()), link),
+	})
+}
+
+func (cfg *apiConfig) magicLinkVerifyHandler(w http.ResponseWriter, req *http.Request) {	//Exchanges a magic link token for the same response as loginHandler
+	type httpRequest struct {
+		Token string `json:"token"`
+		UseCookies bool `json:"use_cookies"`
+	}
+
+	request := httpRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, 400, "Invalid JSON payload")
+		return
+	}
+
+	link, err := auth.ValidateMagicLinkJWT(request.Token, cfg.keys)
+	if err != nil {
+		respondWithError(w, 401, "Login link is invalid or expired")
+		return
+	}
+	fail := func(reason, msg string) {
+		cfg.audit(req, auditEntry{Action: auditLoginMagic, Outcome: auditFailure, ActorID: link.UserID, TargetType: "user", TargetID: link.UserID.String(), Details: map[string]any{"reason": reason}})
+		respondWithError(w, 401, msg)
+	}
+
+	cookie, err := req.Cookie(magicLinkCookie)
+	if err != nil || !magicNonceMatches(cookie.Value, link.NonceHash) {
+		fail("wrong_browser", "Login link must be opened in the browser that asked for it")
+		return
+	}
+
+	user, err := cfg.db.GetUserFromID(req.Context(), link.UserID)
+	if err != nil || !strings.EqualFold(user.Email, link.Email) {	//Links sent before an email change no longer work
+		fail("email_changed", "Login link is invalid or expired")
+		return
+	}
+
+	spent, err := cfg.db.UseMagicLink(req.Context(), database.UseMagicLinkParams{
+		ID: link.ID,
+		ExpiresAt: link.ExpiresAt,	//Kept until the link would have expired anyway
+	})
+	if err != nil {
+		respondWithError(w, 500, "Error checking login link")
+		return
+	}
+	if spent == 0 {
+		fail("reused", "Login link has already been used")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: magicLinkCookie, Path: "/api/login/magic", MaxAge: -1})
+
+	if user.TotpEnabledAt.Valid {	//The link stands in for the password, not the second factor
+		cfg.sendTOTPChallenge(w, user)
+		return
+	}
+	cfg.completeLogin(w, req, auditLoginMagic, user, request.UseCookies)
+}
+
+func magicNonceMatches(nonce, nonceHash string) bool {
+	if nonce == "" || nonceHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth.HashToken(nonce)), []byte(nonceHash)) == 1
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/database"
+	"github.com/jms-guy/chirpy/internal/mail"
+)
+
+func TestMailMagicLink(t *testing.T) {
+	cfg := testConfig(t)
+	mailer := mail.NewMemoryMailer()
+	cfg.mailer = mailer
+	cfg.baseURL = "https://chirpy.example"
+	user := database.User{ID: uuid.New(), Email: "user@example.com"}
+
+	if err := cfg.mailMagicLink(context.Background(), user, auth.HashToken("nonce")); err != nil {
+		t.Fatal(err)
+	}
+	messages := mailer.Messages()
+	if len(messages) != 1 || messages[0].To != user.Email {
+		t.Fatalf("unexpected messages %+v", messages)
+	}
+
+	_, rawLink, ok := strings.Cut(messages[0].Body, cfg.baseURL+"/app/magic-login.html?token=")
+	if !ok {
+		t.Fatalf("no link in body %q", messages[0].Body)
+	}
+	token, err := url.QueryUnescape(strings.Fields(rawLink)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := auth.ValidateMagicLinkJWT(token, cfg.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.UserID != user.ID || !magicNonceMatches("nonce", link.NonceHash) {
+		t.Errorf("link not bound to user and nonce: %+v", link)
+	}
+}
+
+func TestMagicNonceMatches(t *testing.T) {
+	hash := auth.HashToken("nonce")
+	if magicNonceMatches("other", hash) || magicNonceMatches("", hash) || magicNonceMatches("nonce", "") {
+		t.Error("mismatched nonce accepted")
+	}
+}
+
+func TestMagicLinkVerifyHandler(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB(t, cfg)
+	user := db.addUser(database.User{Email: "user@example.com"})
+	token, err := auth.MakeMagicLinkJWT(user.ID, user.Email, auth.HashToken("nonce"), cfg.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(nonce string) int {
+		req := httptest.NewRequest("POST", "/api/login/magic/verify", strings.NewReader(`{"token":"`+token+`"}`))
+		if nonce != "" {
+			req.AddCookie(&http.Cookie{Name: magicLinkCookie, Value: nonce})
+		}
+		rec := httptest.NewRecorder()
+		cfg.magicLinkVerifyHandler(rec, req)
+		return rec.Code
+	}
+
+	if code := verify(""); code != 401 {
+		t.Errorf("link without the nonce cookie got status %d, wanted 401", code)
+	}
+	if code := verify("other"); code != 401 {
+		t.Errorf("link opened in another browser got status %d, wanted 401", code)
+	}
+	if code := verify("nonce"); code != 200 {
+		t.Fatalf("first use got status %d, wanted 200", code)
+	}
+	if code := verify("nonce"); code != 401 {
+		t.Errorf("second use got status %d, wanted 401", code)
+	}
+}
@@ -1,13 +1,25 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 	"database/sql"
+	"github.com/jms-guy/chirpy/internal/auth"
 	"github.com/jms-guy/chirpy/internal/database"
+	"github.com/jms-guy/chirpy/internal/mail"
+	"github.com/jms-guy/chirpy/internal/oidc"
+	"github.com/jms-guy/chirpy/internal/revocation"
+	"github.com/jms-guy/chirpy/internal/storage"
+	"github.com/jms-guy/chirpy/internal/throttle"
+	"github.com/jms-guy/chirpy/internal/webhook"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq" //Postgres driver, imported for side effects needed
 )
@@ -20,8 +32,26 @@ func main() {
 	}
 	dbURL := os.Getenv("DB_URL")	//Grabs database url
 	platformEnv := os.Getenv("PLATFORM")
-	secret := os.Getenv("TOKEN_SECRET")
-	polkaKey := os.Getenv("POLKA_KEY")
+	keysDir := os.Getenv("JWT_KEYS_DIR")	//Directory of <kid>.pem signing keys
+	signingKid := os.Getenv("JWT_SIGNING_KID")
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	requireVerifiedEmail := os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+	secureCookies := platformEnv != "dev"	//Browsers drop Secure cookies over plain HTTP, so local development turns it off
+	if v := os.Getenv("COOKIE_SECURE"); v != "" {
+		secureCookies = v == "true"
+	}
+	deletionGrace := defaultDeletionGrace
+	if v := os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			fmt.Printf("Invalid ACCOUNT_DELETION_GRACE_DAYS: %q", v)
+			os.Exit(1)
+		}
+		deletionGrace = time.Duration(days) * 24 * time.Hour
+	}
 	db, err := sql.Open("postgres", dbURL)	//Opens database connection
 	if err != nil {
 		fmt.Printf("Error opening database connection: %s", err)
@@ -29,11 +59,96 @@ func main() {
 	}
 	dbQueries := database.New(db)	//Grabs generated sqlc queries
 
+	if err := loadArgon2Params(); err != nil {
+		fmt.Printf("Error reading password hashing settings: %s", err)
+		os.Exit(1)
+	}
+
+	passwordPolicy, err := loadPasswordPolicy()
+	if err != nil {
+		fmt.Printf("Error loading password policy: %s", err)
+		os.Exit(1)
+	}
+
+	keys, err := loadKeys(keysDir, signingKid)
+	if err != nil {
+		fmt.Printf("Error loading signing keys: %s", err)
+		os.Exit(1)
+	}
+
+	if adminEmail := os.Getenv("BOOTSTRAP_ADMIN_EMAIL"); adminEmail != "" {	//Promotes an existing, verified account so the first admin can be created without database access
+		bootstrapAdmin(dbQueries, adminEmail)
+	}
+
+	avatarDir := os.Getenv("AVATAR_DIR")	//Defaults to a directory the /app/ file server already serves
+	if avatarDir == "" {
+		avatarDir = "assets/avatars"
+	}
+	avatarURL := os.Getenv("AVATAR_URL")
+	if avatarURL == "" {
+		avatarURL = "/app/assets/avatars"
+	}
+	avatars, err := storage.NewDisk(avatarDir, avatarURL)
+	if err != nil {
+		fmt.Printf("Error setting up avatar storage: %s", err)
+		os.Exit(1)
+	}
+
+	mailer, err := loadMailer(platformEnv)
+	if err != nil {
+		fmt.Printf("Error setting up mailer: %s", err)
+		os.Exit(1)
+	}
+
 	apiCfg := &apiConfig{
 		db: dbQueries,
+		conn: db,
 		platform: platformEnv,
-		tokenSecret: secret,
-		polkaKey: polkaKey,
+		keys: keys,
+		webhooks: loadWebhookVerifier(),
+		webhookWake: make(chan struct{}, 1),
+		mailer: mailer,
+		baseURL: baseURL,
+		requireVerifiedEmail: requireVerifiedEmail,
+		accountLimiter: throttle.New(throttle.Policy{
+			FreeAttempts: 3,
+			BaseDelay: 1 * time.Second,
+			MaxDelay: 30 * time.Second,
+			LockoutAfter: 10,
+			LockoutDuration: 15 * time.Minute,
+			Window: 15 * time.Minute,
+		}),
+		ipLimiter: throttle.New(throttle.Policy{	//Looser than per account, many users can share an IP
+			FreeAttempts: 20,
+			BaseDelay: 1 * time.Second,
+			MaxDelay: 60 * time.Second,
+			LockoutAfter: 100,
+			LockoutDuration: 1 * time.Hour,
+			Window: 1 * time.Hour,
+		}),
+		mailLimiter: throttle.New(throttle.Policy{
+			FreeAttempts: 3,
+			BaseDelay: 1 * time.Minute,
+			MaxDelay: 1 * time.Hour,
+			LockoutAfter: 10,
+			LockoutDuration: 24 * time.Hour,
+			Window: 24 * time.Hour,
+		}),
+		mailIPLimiter: throttle.New(throttle.Policy{
+			FreeAttempts: 10,
+			BaseDelay: 30 * time.Second,
+			MaxDelay: 1 * time.Hour,
+			LockoutAfter: 50,
+			LockoutDuration: 24 * time.Hour,
+			Window: 24 * time.Hour,
+		}),
+		oidc: loadOIDCProvider(baseURL),
+		oidcStates: oidc.NewStateStore(),
+		passwordPolicy: passwordPolicy,
+		revocations: revocation.New(dbRevocationStore{db: dbQueries}, 30 * time.Second),
+		secureCookies: secureCookies,
+		deletionGrace: deletionGrace,
+		avatars: avatars,
 	}
 
 	mux := http.NewServeMux()	//Creates a server mux which routes http requests to handlers
@@ -42,34 +157,240 @@ func main() {
 		Addr: ":8080",
 	}
 
+	registerRoutes(mux, apiCfg)
+
+	go apiCfg.purgeExpired(1 * time.Hour)
+	go apiCfg.expireSubscriptions(10 * time.Minute)
+	go apiCfg.runWebhookWorker(15 * time.Second)
+	go apiCfg.purgeDeletedUsers(1 * time.Hour)
+
+	fmt.Println("Listening...")
+	err = server.ListenAndServe()	//Starts server
+	if errors.Is(err, http.ErrServerClosed) {
+		fmt.Printf("server closed\n")
+	} else if err != nil {
+		fmt.Printf("error starting server: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+type router interface {	//What registerRoutes needs from a mux, so tests can list the routes
+	Handle(pattern string, handler http.Handler)
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
+func registerRoutes(mux router, apiCfg *apiConfig) {
 	mux.Handle("/app/", apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(".")))))	//Handles requests from /app/ endpoints, strips the /app and serves files in base directory
-	mux.HandleFunc("GET /admin/metrics", apiCfg.hitsHandler)	//Handles server response to /admin/metrics	- displays visit count
-	mux.HandleFunc("POST /admin/reset", apiCfg.resetHandler)	//Handles server response to /admin/reset - resets visit count
-	mux.HandleFunc("PUT /api/users", apiCfg.updateUserHandler)
+	//Public routes, no credentials needed
+	mux.HandleFunc("POST /api/users", apiCfg.usersHandler)
+	mux.HandleFunc("GET /api/users/verify", apiCfg.verifyEmailHandler)
+	mux.HandleFunc("GET /api/users/{userId}", apiCfg.getUserHandler)
 	mux.HandleFunc("POST /api/login", apiCfg.loginHandler)
-	mux.HandleFunc("POST /api/revoke", apiCfg.revokeHandler)
+	mux.HandleFunc("POST /api/login/2fa", apiCfg.loginTOTPHandler)
+	mux.HandleFunc("POST /api/login/magic", apiCfg.magicLinkHandler)
+	mux.HandleFunc("POST /api/login/magic/verify", apiCfg.magicLinkVerifyHandler)
+	mux.HandleFunc("GET /api/login/oidc", apiCfg.oidcLoginHandler)
+	mux.HandleFunc("GET /api/login/oidc/callback", apiCfg.oidcCallbackHandler)
+	mux.HandleFunc("POST /api/revoke", apiCfg.revokeHandler)	//Refresh token routes authenticate with the refresh token in the body of the request
 	mux.HandleFunc("POST /api/refresh", apiCfg.refreshHandler)
-	mux.HandleFunc("GET /api/chirps", apiCfg.getAllChirps)
-	mux.HandleFunc("GET /api/chirps/{chirpId}", apiCfg.getSingleChirp)
-	mux.HandleFunc("POST /api/chirps", apiCfg.chirpsHandler)
-	mux.HandleFunc("POST /api/users", apiCfg.usersHandler)
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.forgotPasswordHandler)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.resetPasswordHandler)
 	mux.HandleFunc("POST /api/validate_chirp", validateHandler)
-	mux.HandleFunc("DELETE /api/chirps/{chirpId}", apiCfg.deleteChirpHandler)
-	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.webhookHandler)
+	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.webhookHandler)	//Authenticated by Polka's HMAC signature
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.jwksHandler)	//Serves public keys for verifying access tokens
+
+	//Optional auth, credentials are checked when sent
+	mux.Handle("GET /api/chirps", apiCfg.optionalAuth(auth.ScopeChirpsRead, apiCfg.getAllChirps))
+	mux.Handle("GET /api/chirps/{chirpId}", apiCfg.optionalAuth(auth.ScopeChirpsRead, apiCfg.getSingleChirp))
+
+	//Require auth, an empty scope means personal access tokens are refused
+	mux.Handle("GET /api/users/me", apiCfg.requireAuth(auth.ScopeProfileRead, apiCfg.getMeHandler))
+	mux.Handle("PUT /api/users/me/profile", apiCfg.requireAuth(auth.ScopeProfileWrite, apiCfg.updateProfileHandler))
+	mux.Handle("PUT /api/users/me/avatar", apiCfg.requireAuth(auth.ScopeProfileWrite, apiCfg.uploadAvatarHandler))
+	mux.Handle("DELETE /api/users/me/avatar", apiCfg.requireAuth(auth.ScopeProfileWrite, apiCfg.deleteAvatarHandler))
+	mux.Handle("PUT /api/users", apiCfg.requireAuth("", apiCfg.updateUserHandler))	//Email and password are credentials, which personal access tokens can't change
+	mux.Handle("POST /api/users/verify/resend", apiCfg.requireAuth("", apiCfg.resendVerificationHandler))
+	mux.Handle("DELETE /api/users/me", apiCfg.requireAuth("", apiCfg.deleteAccountHandler))
+	mux.Handle("GET /api/users/me/export", apiCfg.requireAuth("", apiCfg.exportAccountHandler))
+	mux.Handle("POST /api/chirps", apiCfg.requireAuth(auth.ScopeChirpsWrite, apiCfg.chirpsHandler))
+	mux.Handle("DELETE /api/chirps/{chirpId}", apiCfg.requireAuth(auth.ScopeChirpsWrite, apiCfg.deleteChirpHandler))
+	mux.Handle("POST /api/2fa/enroll", apiCfg.requireAuth("", apiCfg.enrollTOTPHandler))
+	mux.Handle("POST /api/2fa/confirm", apiCfg.requireAuth("", apiCfg.confirmTOTPHandler))
+	mux.Handle("POST /api/2fa/disable", apiCfg.requireAuth("", apiCfg.disableTOTPHandler))
+	mux.Handle("GET /api/sessions", apiCfg.requireAuth("", apiCfg.listSessionsHandler))
+	mux.Handle("PUT /api/sessions/{sessionId}", apiCfg.requireAuth("", apiCfg.renameSessionHandler))
+	mux.Handle("DELETE /api/sessions/{sessionId}", apiCfg.requireAuth("", apiCfg.deleteSessionHandler))
+	mux.Handle("POST /api/sessions/revoke-all", apiCfg.requireAuth("", apiCfg.revokeAllSessionsHandler))
+	mux.Handle("POST /api/tokens", apiCfg.requireAuth("", apiCfg.createTokenHandler))	//Personal access tokens can't mint more tokens
+	mux.Handle("GET /api/tokens", apiCfg.requireAuth("", apiCfg.listTokensHandler))
+	mux.Handle("DELETE /api/tokens/{tokenId}", apiCfg.requireAuth("", apiCfg.deleteTokenHandler))
+
+	//Admin routes
+	mux.Handle("GET /admin/metrics", apiCfg.requireRole(auth.RoleAdmin, apiCfg.hitsHandler))	//Handles server response to /admin/metrics	- displays visit count
+	mux.Handle("POST /admin/reset", apiCfg.requireRole(auth.RoleAdmin, apiCfg.resetHandler))	//Handles server response to /admin/reset - resets visit count
+	mux.Handle("PUT /admin/users/{userId}/role", apiCfg.requireRole(auth.RoleAdmin, apiCfg.setRoleHandler))
+	mux.Handle("POST /admin/users/{userId}/ban", apiCfg.requireRole(auth.RoleAdmin, apiCfg.banUserHandler))
+	mux.Handle("DELETE /admin/users/{userId}/ban", apiCfg.requireRole(auth.RoleAdmin, apiCfg.unbanUserHandler))
+	mux.Handle("POST /admin/users/{userId}/impersonate", apiCfg.requireRole(auth.RoleAdmin, apiCfg.impersonateHandler))
+	mux.Handle("GET /admin/webhooks", apiCfg.requireRole(auth.RoleAdmin, apiCfg.listWebhookEventsHandler))
+	mux.Handle("GET /admin/webhooks/{eventId}", apiCfg.requireRole(auth.RoleAdmin, apiCfg.getWebhookEventHandler))
+	mux.Handle("POST /admin/webhooks/{eventId}/replay", apiCfg.requireRole(auth.RoleAdmin, apiCfg.replayWebhookEventHandler))
+	mux.Handle("GET /admin/audit", apiCfg.requireRole(auth.RoleAdmin, apiCfg.listAuditHandler))
+	mux.Handle("GET /admin/audit/export", apiCfg.requireRole(auth.RoleAdmin, apiCfg.exportAuditHandler))
+
 	mux.HandleFunc("GET /api/healthz", func(w http.ResponseWriter, req *http.Request) {	//Handles requests from /healthz endpoint
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")	//Sets response data
 		w.WriteHeader(http.StatusOK)
 		io.WriteString(w, "OK")
 	})
+}
 
-	fmt.Println("Listening...")
-	err = server.ListenAndServe()	//Starts server
-	if errors.Is(err, http.ErrServerClosed) {
-		fmt.Printf("server closed\n")
-	} else if err != nil {
-		fmt.Printf("error starting server: %s\n", err)
-		os.Exit(1)
+func loadArgon2Params() error {	//Reads optional ARGON2_* overrides for the cost of new password hashes
+	params := auth.DefaultArgon2Params
+	for name, field := range map[string]*uint32{
+		"ARGON2_MEMORY_KIB": &params.Memory,
+		"ARGON2_ITERATIONS": &params.Iterations,
+	} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*field = uint32(n)
+		}
+	}
+	if v := os.Getenv("ARGON2_PARALLELISM"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid ARGON2_PARALLELISM: %w", err)
+		}
+		params.Parallelism = uint8(n)
+	}
+	return auth.SetArgon2Params(params)
+}
+
+func loadPasswordPolicy() (auth.PasswordPolicy, error) {	//Reads PASSWORD_MIN_LENGTH, PASSWORD_MIN_ENTROPY and BREACHED_PASSWORDS_FILE over the defaults
+	policy := auth.DefaultPasswordPolicy
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid PASSWORD_MIN_LENGTH: %w", err)
+		}
+		policy.MinLength = n
+	}
+	if v := os.Getenv("PASSWORD_MIN_ENTROPY"); v != "" {
+		bits, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return policy, fmt.Errorf("invalid PASSWORD_MIN_ENTROPY: %w", err)
+		}
+		policy.MinEntropyBits = bits
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		list, err := auth.LoadBreachedList(path)
+		if err != nil {
+			return policy, err
+		}
+		fmt.Printf("Loaded %d breached password hashes\n", list.Len())
+		policy.Breached = list
+	}
+	return policy, nil
+}
+
+func loadWebhookVerifier() *webhook.Verifier {	//Reads POLKA_WEBHOOK_SECRETS, a comma separated list so a new secret can be added before the old one is dropped
+	secrets := os.Getenv("POLKA_WEBHOOK_SECRETS")
+	if secrets == "" {
+		fmt.Println("POLKA_WEBHOOK_SECRETS not set, Polka webhooks are disabled")
+		return nil
+	}
+	verifier, err := webhook.NewVerifier(strings.Split(secrets, ","), 5 * time.Minute)
+	if err != nil {
+		fmt.Printf("Error loading webhook secrets: %s\n", err)
+		return nil
+	}
+	return verifier
+}
+
+func loadKeys(dir, kid string) (*auth.KeyRing, error) {	//Loads the JWT key ring, falling back to a throwaway key when no directory is configured
+	if dir != "" {
+		return auth.LoadKeyRing(dir, kid)
+	}
+	fmt.Println("JWT_KEYS_DIR not set, signing with a temporary key. Tokens will not survive a restart")
+	key, err := auth.GenerateSigningKey("dev")
+	if err != nil {
+		return nil, err
+	}
+	keys := auth.NewKeyRing()
+	if err := keys.Add(key); err != nil {
+		return nil, err
 	}
+	return keys, nil
 }
 
+func loadMailer(platform string) (mail.Mailer, error) {	//Sends through SMTP when a host is configured, otherwise drops messages into a local directory on dev only
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Chirpy <no-reply@chirpy.local>"
+	}
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return mail.NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from), nil
+	}
+	if platform != "dev" {	//Dropped mail holds login, reset and verification links, so it must never happen in production
+		return nil, fmt.Errorf("SMTP_HOST must be set outside the dev platform")
+	}
+	dir := os.Getenv("MAIL_DROP_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "chirpy-maildrop")
+	}
+	if inServedRoot(dir) {
+		return nil, fmt.Errorf("MAIL_DROP_DIR %s is inside the directory served at /app/", dir)
+	}
+	fmt.Printf("SMTP_HOST not set, writing outgoing mail to %s\n", dir)
+	return mail.NewFileMailer(dir, from)
+}
 
+func inServedRoot(dir string) bool {	//Whether dir sits under the working directory, which fileServerHandler serves to anyone
+	root, err := filepath.Abs(".")
+	if err != nil {
+		return true
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return true
+	}
+	rel, err := filepath.Rel(root, abs)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func loadOIDCProvider(baseURL string) *oidc.Provider {	//Returns nil unless an OIDC issuer is configured
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = baseURL + "/api/login/oidc/callback"
+	}
+	return oidc.NewProvider(oidc.Config{
+		Issuer: issuer,
+		ClientID: os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL: redirectURL,
+	})
+}
+
+func bootstrapAdmin(db *database.Queries, email string) {	//Only ever creates the first admin, so a demoted account isn't promoted again on restart
+	updated, err := db.BootstrapAdmin(context.Background(), email)
+	if err != nil {
+		fmt.Printf("Error promoting %s to admin: %s\n", email, err)
+		return
+	}
+	if updated == 0 {
+		fmt.Printf("BOOTSTRAP_ADMIN_EMAIL ignored, either an admin already exists or %s has no account with a verified email\n", email)
+		return
+	}
+	fmt.Printf("Promoted %s to admin\n", email)
+}
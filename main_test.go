@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jms-guy/chirpy/internal/profile"
+)
+
+func TestLoadMailerFileDrop(t *testing.T) {
+	t.Setenv("SMTP_HOST", "")
+	t.Setenv("MAIL_DROP_DIR", "")
+	if _, err := loadMailer("prod"); err == nil {
+		t.Error("file mailer allowed outside dev")
+	}
+
+	t.Setenv("MAIL_DROP_DIR", "maildrop")
+	if _, err := loadMailer("dev"); err == nil {
+		t.Error("drop directory inside the served root allowed")
+	}
+	os.Remove("maildrop")
+
+	t.Setenv("MAIL_DROP_DIR", filepath.Join(t.TempDir(), "mail"))
+	if _, err := loadMailer("dev"); err != nil {
+		t.Errorf("dev file mailer refused: %s", err)
+	}
+}
+
+func TestInServedRoot(t *testing.T) {
+	for dir, want := range map[string]bool{
+		".": true,
+		"maildrop": true,
+		"./assets/../maildrop": true,
+		"../maildrop": false,
+		"..maildrop": true,
+		os.TempDir(): false,
+	} {
+		if got := inServedRoot(dir); got != want {
+			t.Errorf("inServedRoot(%q) = %v, wanted %v", dir, got, want)
+		}
+	}
+}
+
+type routeRecorder []string
+
+func (r *routeRecorder) Handle(pattern string, handler http.Handler) {
+	*r = append(*r, pattern)
+}
+
+func (r *routeRecorder) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	*r = append(*r, pattern)
+}
+
+func TestUserRoutesReserveHandles(t *testing.T) {	//GET /api/users/{userId} also takes a handle, which literal routes beside it would shadow
+	routes := routeRecorder{}
+	registerRoutes(&routes, &apiConfig{})
+	checked := 0
+	for _, pattern := range routes {
+		_, path, _ := strings.Cut(pattern, " ")
+		rest, ok := strings.CutPrefix(path, "/api/users/")
+		if !ok {
+			continue
+		}
+		segment, _, _ := strings.Cut(rest, "/")
+		if strings.HasPrefix(segment, "{") {
+			continue
+		}
+		checked++
+		if profile.ValidateHandle(segment) == nil {
+			t.Errorf("handle %q is allowed but shadowed by %s", segment, pattern)
+		}
+	}
+	if checked == 0 {
+		t.Error("no literal /api/users/ routes found")
+	}
+}
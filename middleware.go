@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/revocation"
+)
+
+const (
+	authMethodJWT = "jwt"
+	authMethodPAT = "pat"
+	authMethodCookie = "cookie"	//An access token from a browser session cookie
+)
+
+type Principal struct {	//The authenticated caller of a request
+	UserID uuid.UUID
+	Role string	//Empty for personal access tokens, which never carry a role
+	Method string	//How the caller authenticated, one of the authMethod constants
+	Scopes []string	//Granted scopes, only meaningful for personal access tokens
+	ImpersonatorID uuid.UUID	//Admin acting as UserID with an impersonation token, uuid.Nil otherwise
+	ReadOnly bool
+	AuthTime time.Time	//When the user last logged in, zero for personal access and impersonation tokens
+}
+
+func (p Principal) Authenticated() bool {
+	return p.Method != ""
+}
+
+func (p Principal) Impersonated() bool {
+	return p.ImpersonatorID != uuid.Nil
+}
+
+func (p Principal) HasScope(scope string) bool {	//Access tokens from a login carry every scope, personal access tokens only those granted to them
+	if p.Method == authMethodPAT {	//An empty scope means the route wants a logged in user, which a personal access token never is
+		return scope != "" && slices.Contains(p.Scopes, scope)
+	}
+	return p.Authenticated()
+}
+
+type principalKey struct{}
+
+func principalFrom(ctx context.Context) Principal {	//Returns the caller stored by the auth middleware, or the zero Principal for anonymous requests
+	p, _ := ctx.Value(principalKey{}).(Principal)
+	return p
+}
+
+var (
+	errNoCredentials = errors.New("no credentials provided")
+	errUnauthenticated = errors.New("missing or invalid token")
+)
+
+func (cfg *apiConfig) resolvePrincipal(req *http.Request) (Principal, error) {	//Works out who is calling from a bearer JWT, personal access token or session cookie
+	token, fromCookie, err := sessionToken(req, accessCookie)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	if !fromCookie && auth.IsPersonalAccessToken(token) {
+		pat, err := cfg.db.GetPersonalAccessToken(req.Context(), auth.HashToken(token))
+		if err != nil {
+			return Principal{}, errUnauthenticated
+		}
+		if state, err := cfg.revocations.User(req.Context(), pat.UserID); err != nil || state.Banned {
+			return Principal{}, errUnauthenticated
+		}
+		if err := cfg.db.TouchPersonalAccessToken(req.Context(), pat.ID); err != nil {
+			fmt.Printf("Error updating last use of token %s: %s\n", pat.ID, err)
+		}
+		return Principal{UserID: pat.UserID, Method: authMethodPAT, Scopes: pat.Scopes}, nil
+	}
+
+	claims, err := auth.ValidateJWT(token, cfg.keys)
+	if err != nil {
+		return Principal{}, errUnauthenticated
+	}
+	if err := cfg.revocations.Check(req.Context(), claims.UserID, claims.Version, claims.ID, claims.SessionID); err != nil {
+		if !errors.Is(err, revocation.ErrRevoked) && !errors.Is(err, revocation.ErrBanned) {
+			fmt.Printf("Error checking revocation of token %s: %s\n", claims.ID, err)
+		}
+		return Principal{}, errUnauthenticated
+	}
+	if claims.ActorID != uuid.Nil {	//Impersonation ends as soon as the admin is banned, demoted or has their own tokens revoked
+		state, err := cfg.revocations.User(req.Context(), claims.ActorID)
+		if err != nil || state.Banned || state.TokenVersion != claims.ActorVersion || !auth.HasRole(state.Role, auth.RoleAdmin) {
+			return Principal{}, errUnauthenticated
+		}
+	}
+	method := authMethodJWT
+	if fromCookie {
+		method = authMethodCookie
+	}
+	return Principal{
+		UserID: claims.UserID,
+		Role: claims.Role,
+		Method: method,
+		ImpersonatorID: claims.ActorID,
+		ReadOnly: claims.ReadOnly,
+		AuthTime: claims.AuthTime,
+	}, nil
+}
+
+func (cfg *apiConfig) optionalAuth(scope string, next http.HandlerFunc) http.Handler {	//For routes anyone can call. Credentials are still checked when given, and the caller stored for the handler
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		principal, err := cfg.resolvePrincipal(req)
+		if errors.Is(err, errNoCredentials) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		if !principal.HasScope(scope) {
+			respondWithError(w, 403, "Token does not have permission for this action")
+			return
+		}
+		if principal.Impersonated() && !cfg.allowImpersonated(w, req, principal, scope) {
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), principalKey{}, principal)))
+	})
+}
+
+func (cfg *apiConfig) requireAuth(scope string, next http.HandlerFunc) http.Handler {	//For routes that need a caller. An empty scope means only a logged in user will do, not a personal access token
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		principal, err := cfg.resolvePrincipal(req)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		if !principal.HasScope(scope) {
+			respondWithError(w, 403, "Token does not have permission for this action")
+			return
+		}
+		if principal.Impersonated() && !cfg.allowImpersonated(w, req, principal, scope) {
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), principalKey{}, principal)))
+	})
+}
+
+func (cfg *apiConfig) requireRole(role string, next http.HandlerFunc) http.Handler {	//Wraps a handler so only logged in users with role, or a higher one, reach it
+	return cfg.requireAuth("", func(w http.ResponseWriter, req *http.Request) {
+		if !auth.HasRole(principalFrom(req.Context()).Role, role) {
+			respondWithError(w, 403, "User does not have access to this page")
+			return
+		}
+		next(w, req)
+	})
+}
+
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNoCredentials):
+		respondWithError(w, 401, "Authentication required")
+	case errors.Is(err, errCSRF):
+		respondWithError(w, 403, "Missing or invalid CSRF token")
+	default:
+		respondWithError(w, 401, "Invalid or expired token")
+	}
+}
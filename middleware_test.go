@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/revocation"
+	"github.com/jms-guy/chirpy/internal/throttle"
+)
+
+func testConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	key, err := auth.GenerateSigningKey("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := auth.NewKeyRing()
+	if err := keys.Add(key); err != nil {
+		t.Fatal(err)
+	}
+	limits := throttle.Policy{FreeAttempts: 5, LockoutAfter: 5, LockoutDuration: time.Minute, Window: time.Minute}
+	return &apiConfig{
+		keys: keys,
+		revocations: revocation.New(stubRevocationStore{}, time.Minute),
+		accountLimiter: throttle.New(limits),
+		ipLimiter: throttle.New(limits),
+	}
+}
+
+type stubRevocationStore struct{}	//Nothing revoked, Checker.Deny still takes effect through its cache
+
+func (stubRevocationStore) UserState(ctx context.Context, userID uuid.UUID) (revocation.UserState, error) {
+	return revocation.UserState{}, nil
+}
+
+func (stubRevocationStore) IsDenied(ctx context.Context, id uuid.UUID) (bool, error) {
+	return false, nil
+}
+
+func (stubRevocationStore) Deny(ctx context.Context, id uuid.UUID, until time.Time) error {
+	return nil
+}
+
+func TestRequireAuth(t *testing.T) {
+	cfg := testConfig(t)
+	userID := uuid.New()
+	token, err := auth.MakeJWT(auth.AccessToken{UserID: userID, Role: auth.RoleUser}, cfg.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Principal
+	handler := cfg.requireAuth(auth.ScopeChirpsWrite, func(w http.ResponseWriter, req *http.Request) {
+		got = principalFrom(req.Context())
+	})
+
+	tests := []struct {
+		header string
+		want int
+	}{
+		{"", 401},
+		{"Bearer not-a-token", 401},
+		{"Bearer " + token, 200},
+	}
+	for _, tc := range tests {
+		got = Principal{}
+		req := httptest.NewRequest("POST", "/api/chirps", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("header %q: got status %d, wanted %d", tc.header, rec.Code, tc.want)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/api/chirps", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got.UserID != userID || got.Method != authMethodJWT || got.Role != auth.RoleUser {
+		t.Errorf("unexpected principal %+v", got)
+	}
+}
+
+func TestOptionalAuth(t *testing.T) {
+	cfg := testConfig(t)
+	called := false
+	handler := cfg.optionalAuth(auth.ScopeChirpsRead, func(w http.ResponseWriter, req *http.Request) {
+		called = true
+		if principalFrom(req.Context()).Authenticated() {
+			t.Error("anonymous request should have no principal")
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/chirps", nil))
+	if !called || rec.Code != 200 {
+		t.Errorf("anonymous request was not let through, status %d", rec.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/chirps", nil)
+	req.Header.Set("Authorization", "Bearer not-a-token")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 401 {
+		t.Errorf("bad token got status %d, wanted 401", rec.Code)
+	}
+}
+
+func TestPrincipalScopes(t *testing.T) {
+	login := Principal{UserID: uuid.New(), Method: authMethodJWT}
+	pat := Principal{UserID: uuid.New(), Method: authMethodPAT, Scopes: []string{auth.ScopeChirpsRead}}
+
+	if !login.HasScope("") || !login.HasScope(auth.ScopeChirpsWrite) {
+		t.Error("access tokens should carry every scope")
+	}
+	if pat.HasScope("") || pat.HasScope(auth.ScopeChirpsWrite) || !pat.HasScope(auth.ScopeChirpsRead) {
+		t.Error("personal access tokens should only carry granted scopes")
+	}
+	if (Principal{}).HasScope(auth.ScopeChirpsRead) {
+		t.Error("anonymous callers have no scopes")
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	cfg := testConfig(t)
+	handler := cfg.requireRole(auth.RoleAdmin, func(w http.ResponseWriter, req *http.Request) {})
+
+	for role, want := range map[string]int{auth.RoleUser: 403, auth.RoleModerator: 403, auth.RoleAdmin: 200} {
+		token, err := auth.MakeJWT(auth.AccessToken{UserID: uuid.New(), Role: role}, cfg.keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", "/admin/metrics", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("role %s: got status %d, wanted %d", role, rec.Code, want)
+		}
+	}
+}
+
+func TestCookieAuthRequiresCSRF(t *testing.T) {
+	cfg := testConfig(t)
+	token, err := auth.MakeJWT(auth.AccessToken{UserID: uuid.New(), Role: auth.RoleUser}, cfg.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Principal
+	handler := cfg.requireAuth("", func(w http.ResponseWriter, req *http.Request) {
+		got = principalFrom(req.Context())
+	})
+
+	tests := []struct {
+		method string
+		header string
+		want int
+	}{
+		{"GET", "", 200},	//Reads don't need the CSRF token
+		{"POST", "", 403},
+		{"POST", "wrong", 403},
+		{"POST", "csrf-value", 200},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, "/api/sessions", nil)
+		req.AddCookie(&http.Cookie{Name: accessCookie, Value: token})
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: "csrf-value"})
+		if tc.header != "" {
+			req.Header.Set(csrfHeader, tc.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s with csrf header %q: got status %d, wanted %d", tc.method, tc.header, rec.Code, tc.want)
+		}
+	}
+	if got.Method != authMethodCookie {
+		t.Errorf("got method %q, wanted %q", got.Method, authMethodCookie)
+	}
+}
+
+func TestSessionCookies(t *testing.T) {
+	cfg := &apiConfig{secureCookies: true}
+	rec := httptest.NewRecorder()
+	cfg.setSessionCookies(rec, "access", "refresh", "csrf")
+
+	cookies := map[string]*http.Cookie{}
+	for _, c := range rec.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+	for _, name := range []string{accessCookie, refreshCookie} {
+		c, ok := cookies[name]
+		if !ok || !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteStrictMode {
+			t.Errorf("cookie %s is missing or not locked down: %+v", name, c)
+		}
+	}
+	if c, ok := cookies[csrfCookie]; !ok || c.HttpOnly {
+		t.Errorf("csrf cookie must be readable by scripts: %+v", c)
+	}
+}
+
+func TestRevokedSessionRejected(t *testing.T) {
+	cfg := testConfig(t)
+	session := uuid.New()
+	token, err := auth.MakeJWT(auth.AccessToken{UserID: uuid.New(), Role: auth.RoleUser, SessionID: session}, cfg.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := cfg.requireAuth("", func(w http.ResponseWriter, req *http.Request) {})
+
+	serve := func() int {
+		req := httptest.NewRequest("GET", "/api/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := serve(); code != 200 {
+		t.Fatalf("got status %d before revoking, wanted 200", code)
+	}
+	cfg.denySession(context.Background(), session)
+	if code := serve(); code != 401 {
+		t.Errorf("got status %d after revoking, wanted 401", code)
+	}
+}
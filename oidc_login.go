@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/database"
+	"github.com/jms-guy/chirpy/internal/oidc"
+)
+
+const oidcStateCookie = "chirpy_oidc_state"
+
+func (cfg *apiConfig) oidcLoginHandler(w http.ResponseWriter, req *http.Request) {	//Starts single sign-on by sending the browser to the identity provider. ?use_cookies=true asks for a cookie session like loginHandler's use_cookies
+	if cfg.oidc == nil {
+		respondWithError(w, 404, "Single sign-on is not configured")
+		return
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		respondWithError(w, 500, "Error starting login")
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		respondWithError(w, 500, "Error starting login")
+		return
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		respondWithError(w, 500, "Error starting login")
+		return
+	}
+
+	authURL, err := cfg.oidc.AuthCodeURL(req.Context(), state, nonce, verifier)
+	if err != nil {
+		fmt.Printf("Error building OIDC authorization URL: %s\n", err)
+		respondWithError(w, 502, "Error contacting identity provider")
+		return
+	}
+	cfg.oidcStates.Put(state, oidc.FlowState{
+		Nonce: nonce,
+		CodeVerifier: verifier,
+		UseCookies: req.URL.Query().Get("use_cookies") == "true",
+		ExpiresAt: time.Now().Add(10 * time.Minute),
+	})
+
+	http.SetCookie(w, &http.Cookie{	//Ties the callback to the browser that started the login
+		Name: oidcStateCookie,
+		Value: state,
+		Path: "/api/login/oidc",
+		MaxAge: 600,
+		HttpOnly: true,
+		Secure: cfg.secureCookies,
+		SameSite: http.SameSiteLaxMode,	//Lax so the cookie survives the top level redirect back from the provider
+	})
+	http.Redirect(w, req, authURL, http.StatusFound)
+}
+
+func (cfg *apiConfig) oidcCallbackHandler(w http.ResponseWriter, req *http.Request) {	//Finishes single sign-on and returns the same tokens as loginHandler
+	if cfg.oidc == nil {
+		respondWithError(w, 404, "Single sign-on is not configured")
+		return
+	}
+
+	query := req.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		fmt.Printf("Identity provider returned error: %s\n", providerErr)
+		respondWithError(w, 401, "Single sign-on failed")
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := req.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		respondWithError(w, 400, "Invalid login state")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/login/oidc", MaxAge: -1})
+
+	flow, ok := cfg.oidcStates.Take(state)
+	if !ok {
+		respondWithError(w, 400, "Login has expired, please try again")
+		return
+	}
+
+	idToken, err := cfg.oidc.Exchange(req.Context(), query.Get("code"), flow.CodeVerifier, flow.Nonce)
+	if err != nil {
+		fmt.Printf("Error completing OIDC login: %s\n", err)
+		respondWithError(w, 401, "Single sign-on failed")
+		return
+	}
+
+	user, err := cfg.userForIdentity(req.Context(), idToken)
+	if err != nil {
+		fmt.Printf("Error linking identity %s/%s: %s\n", idToken.Issuer, idToken.Subject, err)
+		respondWithError(w, 403, "Could not link this identity to a Chirpy account")
+		return
+	}
+
+	if user.TotpEnabledAt.Valid {	//The provider stands in for the password, not for a second factor enrolled here
+		cfg.sendTOTPChallenge(w, user)
+		return
+	}
+	cfg.completeLogin(w, req, auditLoginOIDC, user, flow.UseCookies)
+}
+
+func (cfg *apiConfig) userForIdentity(ctx context.Context, idToken *oidc.IDToken) (database.User, error) {	//Finds the user linked to an external identity, linking or creating one on first login
+	user, err := cfg.db.GetUserFromIdentity(ctx, database.GetUserFromIdentityParams{
+		Issuer: idToken.Issuer,
+		Subject: idToken.Subject,
+	})
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if idToken.Email == "" {
+		return database.User{}, fmt.Errorf("provider did not share an email address")
+	}
+
+	err = cfg.inTx(ctx, func(q *database.Queries) error {	//A failed link must not leave a passwordless user behind to block the email
+		user, err = linkIdentity(ctx, q, idToken)
+		return err
+	})
+	if isUniqueViolation(err) {	//A concurrent callback for the same identity or email got there first
+		return cfg.db.GetUserFromIdentity(ctx, database.GetUserFromIdentityParams{
+			Issuer: idToken.Issuer,
+			Subject: idToken.Subject,
+		})
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
+
+func linkIdentity(ctx context.Context, q *database.Queries, idToken *oidc.IDToken) (database.User, error) {	//Links an external identity to the account with its email, creating the account if there is none
+	user, err := q.GetUserFromEmail(ctx, idToken.Email)
+	switch {
+	case err == nil:
+		if !idToken.EmailVerified {	//Only link to an existing account when the provider vouches for the address
+			return database.User{}, fmt.Errorf("email %s is not verified by the provider", idToken.Email)
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		user, err = q.CreateUser(ctx, database.CreateUserParams{
+			ID: uuid.New(),
+			Email: idToken.Email,
+			HashedPassword: auth.NoPassword,	//SSO users have no password until they set one through a reset
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+	default:
+		return database.User{}, err
+	}
+
+	if idToken.EmailVerified && !user.EmailVerifiedAt.Valid {
+		if _, err := q.VerifyUserEmail(ctx, database.VerifyUserEmailParams{ID: user.ID, Email: user.Email}); err != nil {
+			return database.User{}, err
+		}
+		user.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+
+	if _, err := q.CreateIdentity(ctx, database.CreateIdentityParams{
+		ID: uuid.New(),
+		UserID: user.ID,
+		Issuer: idToken.Issuer,
+		Subject: idToken.Subject,
+		Email: idToken.Email,
+	}); err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
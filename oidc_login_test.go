@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/database"
+	"github.com/jms-guy/chirpy/internal/oidc"
+	"github.com/lib/pq"
+)
+
+func TestUserForIdentityConcurrentLink(t *testing.T) {	//The callback that loses the race to link an identity logs in as the winner's user
+	cfg := testConfig(t)
+	db := newFakeDB(t, cfg)
+	winner := db.addUser(database.User{Email: "user@example.com", HashedPassword: auth.NoPassword})
+
+	linked := false
+	db.handle("GetUserFromIdentity", func(args []driver.Value) (fakeResult, error) {
+		if !linked {
+			return fakeResult{columns: userColumns}, nil
+		}
+		return fakeResult{columns: userColumns, rows: [][]driver.Value{userRow(winner)}}, nil
+	})
+	db.handle("GetUserFromEmail", func(args []driver.Value) (fakeResult, error) {	//Not committed yet when this callback looked
+		return fakeResult{columns: userColumns}, nil
+	})
+	db.handle("CreateUser", func(args []driver.Value) (fakeResult, error) {
+		return fakeResult{columns: userColumns, rows: [][]driver.Value{userRow(database.User{ID: uuid.MustParse(args[0].(string)), Email: args[1].(string), HashedPassword: args[2].(string)})}}, nil
+	})
+	db.handle("CreateIdentity", func(args []driver.Value) (fakeResult, error) {
+		linked = true
+		return fakeResult{}, &pq.Error{Code: "23505"}
+	})
+
+	user, err := cfg.userForIdentity(context.Background(), &oidc.IDToken{Issuer: "https://idp.example", Subject: "123", Email: winner.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != winner.ID {
+		t.Errorf("got user %s, wanted the already linked %s", user.ID, winner.ID)
+	}
+}
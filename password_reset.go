@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/database"
+	"github.com/jms-guy/chirpy/internal/mail"
+)
+
+const passwordResetTTL = 1 * time.Hour
+
+func (cfg *apiConfig) forgotPasswordHandler(w http.ResponseWriter, req *http.Request) {	//Emails a password reset link. Always responds the same way so it can't reveal which emails have accounts
+	type httpRequest struct {
+		Email string `json:"email"`
+	}
+
+	request := httpRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, 400, "Invalid JSON payload")
+		return
+	}
+	if !cfg.allowMail(w, req, request.Email) {
+		return
+	}
+
+	go cfg.sendPasswordReset(request.Email)	//Done after responding so response time doesn't depend on whether the account exists
+
+	respondWithJSON(w, 202, map[string]string{"status": "If an account exists for that email, a reset link has been sent"})
+}
+
+func (cfg *apiConfig) sendPasswordReset(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user, err := cfg.db.GetUserFromEmail(ctx, email)
+	if err != nil {
+		return
+	}
+
+	token, err := auth.MakeRefreshToken()	//Same random 256-bit token as refresh tokens, only its digest is stored
+	if err != nil {
+		fmt.Printf("Error creating password reset token: %s\n", err)
+		return
+	}
+	if err := cfg.db.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID: user.ID,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}); err != nil {
+		fmt.Printf("Error saving password reset token for user %s: %s\n", user.ID, err)
+		return
+	}
+
+	link := cfg.baseURL + "/app/reset-password.html?token=" + url.QueryEscape(token)
+	if err := cfg.mailer.Send(ctx, mail.Message{
+		To: user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: "Someone asked to reset the password for your Chirpy account.\n\nOpen the link below within the next hour to choose a new password:\n\n" + link + "\n\nIf it wasn't you, you can ignore this email and your password will stay the same.\n",
+	}); err != nil {
+		fmt.Printf("Error sending password reset email to user %s: %s\n", user.ID, err)
+	}
+}
+
+func (cfg *apiConfig) resetPasswordHandler(w http.ResponseWriter, req *http.Request) {	//Sets a new password from a reset token, then logs the user out everywhere
+	type httpRequest struct {
+		Token string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	request := httpRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, 400, "Invalid JSON payload")
+		return
+	}
+
+	user, err := cfg.db.GetUserFromPasswordResetToken(req.Context(), auth.HashToken(request.Token))	//Checked before the token is spent, so a rejected password doesn't burn the link
+	if err != nil {
+		respondWithError(w, 400, "Reset token is invalid or expired")
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, request.Password, user.Email) {
+		return
+	}
+	hash, err := auth.HashPassword(request.Password)
+	if err != nil {
+		fmt.Printf("Error hashing password: %s", err)
+		respondWithError(w, 400, "Invalid password string")
+		return
+	}
+
+	userId, err := cfg.db.UsePasswordResetToken(req.Context(), auth.HashToken(request.Token))
+	if err != nil {
+		respondWithError(w, 400, "Reset token is invalid or expired")
+		return
+	}
+
+	if err := cfg.db.UpdatePassword(req.Context(), database.UpdatePasswordParams{
+		HashedPassword: hash,
+		ID: userId,
+	}); err != nil {
+		fmt.Printf("Error updating password for user %s: %s\n", userId, err)
+		respondWithError(w, 500, "Error updating password")
+		return
+	}
+	if err := cfg.db.InvalidatePasswordResetTokens(req.Context(), userId); err != nil {	//Any other outstanding links die with this one
+		fmt.Printf("Error invalidating password reset tokens for user %s: %s\n", userId, err)
+	}
+	if err := cfg.db.RevokeUserTokens(req.Context(), userId); err != nil {
+		fmt.Printf("Error revoking sessions for user %s: %s\n", userId, err)
+		respondWithError(w, 500, "Error revoking sessions")
+		return
+	}
+	if err := cfg.revokeAccessTokens(req.Context(), userId); err != nil {
+		fmt.Printf("Error revoking access tokens for user %s: %s\n", userId, err)
+	}
+	cfg.audit(req, auditEntry{Action: auditPasswordReset, ActorID: userId, TargetType: "user", TargetID: userId.String()})
+	w.WriteHeader(204)
+}
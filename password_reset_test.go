@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jms-guy/chirpy/internal/throttle"
+)
+
+func TestAllowMail(t *testing.T) {
+	cfg := testConfig(t)
+	policy := throttle.Policy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	cfg.mailLimiter = throttle.New(policy)
+	cfg.mailIPLimiter = throttle.New(throttle.Policy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour})
+
+	send := func(email, ip string) int {
+		req := httptest.NewRequest("POST", "/api/password/forgot", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		if cfg.allowMail(w, req, email) {
+			return 202
+		}
+		return w.Code
+	}
+
+	for i, want := range []int{202, 202, 202, 429} {	//The third send is let through, then the address has to wait
+		if got := send("User@Example.com", "10.0.0.1"); got != want {
+			t.Errorf("send %d to address: got %d, wanted %d", i+1, got, want)
+		}
+	}
+	if got := send("user@example.com", "10.0.0.2"); got != 429 {
+		t.Errorf("address limit ignored letter case or IP: got %d", got)
+	}
+	if got := send("other@example.com", "10.0.0.1"); got != 429 {	//Three sends from this IP so far
+		t.Errorf("IP limit not applied: got %d", got)
+	}
+	if got := send("other@example.com", "10.0.0.3"); got != 202 {
+		t.Errorf("unrelated address and IP throttled: got %d", got)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+type PersonalAccessToken struct {
+	ID uuid.UUID `json:"id"`
+	Name string `json:"name"`
+	Scopes []string `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Token string `json:"token,omitempty"`	//Only returned once, when the token is created
+}
+
+func toPersonalAccessToken(t database.PersonalAccessToken) PersonalAccessToken {
+	pat := PersonalAccessToken{
+		ID: t.ID,
+		Name: t.Name,
+		Scopes: t.Scopes,
+		CreatedAt: t.CreatedAt,
+	}
+	if t.ExpiresAt.Valid {
+		pat.ExpiresAt = &t.ExpiresAt.Time
+	}
+	if t.LastUsedAt.Valid {
+		pat.LastUsedAt = &t.LastUsedAt.Time
+	}
+	return pat
+}
+
+func (cfg *apiConfig) createTokenHandler(w http.ResponseWriter, req *http.Request) {	//Creates a named, scoped personal access token for the caller
+	type httpRequest struct {
+		Name string `json:"name"`
+		Scopes []string `json:"scopes"`
+		ExpiresInDays int `json:"expires_in_days"`	//Zero means the token never expires
+	}
+
+	userId := principalFrom(req.Context()).UserID
+
+	request := httpRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, 400, "Invalid JSON payload")
+		return
+	}
+	if request.Name == "" || len(request.Name) > 100 {
+		respondWithError(w, 400, "Token name must be between 1 and 100 characters")
+		return
+	}
+	if len(request.Scopes) == 0 {
+		respondWithError(w, 400, "At least one scope is required")
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !auth.ValidScope(scope) {
+			respondWithError(w, 400, fmt.Sprintf("Unknown scope %q", scope))
+			return
+		}
+	}
+	if request.ExpiresInDays < 0 {
+		respondWithError(w, 400, "Expiry must not be negative")
+		return
+	}
+
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		respondWithError(w, 500, "Error creating token")
+		return
+	}
+	expiresAt := sql.NullTime{}
+	if request.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().AddDate(0, 0, request.ExpiresInDays), Valid: true}
+	}
+
+	created, err := cfg.db.CreatePersonalAccessToken(req.Context(), database.CreatePersonalAccessTokenParams{
+		ID: uuid.New(),
+		UserID: userId,
+		Name: request.Name,
+		TokenHash: auth.HashToken(token),
+		Scopes: request.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		fmt.Printf("Error creating personal access token for user %s: %s\n", userId, err)
+		respondWithError(w, 500, "Error creating token")
+		return
+	}
+
+	cfg.audit(req, auditEntry{Action: auditTokenCreate, TargetType: "personal_access_token", TargetID: created.ID.String(), Details: map[string]any{"name": request.Name, "scopes": request.Scopes}})
+	pat := toPersonalAccessToken(created)
+	pat.Token = token
+	respondWithJSON(w, 201, pat)
+}
+
+func (cfg *apiConfig) listTokensHandler(w http.ResponseWriter, req *http.Request) {	//Lists the caller's personal access tokens, without the token values
+	userId := principalFrom(req.Context()).UserID
+
+	tokens, err := cfg.db.ListPersonalAccessTokens(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 500, "Error getting tokens")
+		return
+	}
+	pats := []PersonalAccessToken{}
+	for _, t := range tokens {
+		pats = append(pats, toPersonalAccessToken(t))
+	}
+	respondWithJSON(w, 200, pats)
+}
+
+func (cfg *apiConfig) deleteTokenHandler(w http.ResponseWriter, req *http.Request) {	//Revokes one of the caller's personal access tokens
+	userId := principalFrom(req.Context()).UserID
+
+	id, err := uuid.Parse(req.PathValue("tokenId"))
+	if err != nil {
+		respondWithError(w, 404, "Token not found")
+		return
+	}
+
+	revoked, err := cfg.db.RevokePersonalAccessToken(req.Context(), database.RevokePersonalAccessTokenParams{
+		ID: id,
+		UserID: userId,
+	})
+	if err != nil {
+		respondWithError(w, 500, "Error revoking token")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, 404, "Token not found")
+		return
+	}
+	cfg.audit(req, auditEntry{Action: auditTokenDelete, TargetType: "personal_access_token", TargetID: id.String()})
+	w.WriteHeader(204)
+}
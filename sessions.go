@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+type Session struct {	//A logged in device, backed by the active refresh token of a token family
+	ID uuid.UUID `json:"id"`
+	Name string `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string `json:"user_agent"`
+	IPAddress string `json:"ip_address"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`	//Only in data exports, listed sessions are all active
+}
+
+func (cfg *apiConfig) listSessionsHandler(w http.ResponseWriter, req *http.Request) {	//Lists the caller's active sessions, most recently used first
+	userId := principalFrom(req.Context()).UserID
+
+	tokens, err := cfg.db.ListActiveSessions(req.Context(), userId)
+	if err != nil {
+		fmt.Printf("Error getting sessions for user %s: %s\n", userId, err)
+		respondWithError(w, 500, "Error getting sessions")
+		return
+	}
+
+	sessions := []Session{}
+	for _, t := range tokens {
+		sessions = append(sessions, Session{
+			ID: t.FamilyID,
+			Name: t.Name,
+			CreatedAt: t.SessionCreatedAt,
+			LastUsedAt: t.LastUsedAt,
+			ExpiresAt: t.ExpiresAt,
+			UserAgent: t.UserAgent,
+			IPAddress: t.IpAddress,
+		})
+	}
+	respondWithJSON(w, 200, sessions)
+}
+
+func (cfg *apiConfig) renameSessionHandler(w http.ResponseWriter, req *http.Request) {	//Gives one of the caller's sessions a display name
+	type httpRequest struct {
+		Name string `json:"name"`
+	}
+
+	userId := principalFrom(req.Context()).UserID
+
+	id, err := uuid.Parse(req.PathValue("sessionId"))
+	if err != nil {
+		respondWithError(w, 404, "Session not found")
+		return
+	}
+
+	request := httpRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, 400, "Invalid JSON payload")
+		return
+	}
+	if len(request.Name) > 100 {
+		respondWithError(w, 400, "Session name is too long")
+		return
+	}
+
+	renamed, err := cfg.db.RenameUserSession(req.Context(), database.RenameUserSessionParams{
+		Name: request.Name,
+		UserID: userId,
+		FamilyID: id,
+	})
+	if err != nil {
+		respondWithError(w, 500, "Error renaming session")
+		return
+	}
+	if renamed == 0 {	//Sessions owned by other users look the same as missing ones
+		respondWithError(w, 404, "Session not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) deleteSessionHandler(w http.ResponseWriter, req *http.Request) {	//Logs a single device out by revoking its token family
+	userId := principalFrom(req.Context()).UserID
+
+	id, err := uuid.Parse(req.PathValue("sessionId"))
+	if err != nil {
+		respondWithError(w, 404, "Session not found")
+		return
+	}
+
+	revoked, err := cfg.db.RevokeUserSession(req.Context(), database.RevokeUserSessionParams{
+		UserID: userId,
+		FamilyID: id,
+	})
+	if err != nil {
+		respondWithError(w, 500, "Error revoking session")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, 404, "Session not found")
+		return
+	}
+	cfg.denySession(req.Context(), id)
+	cfg.audit(req, auditEntry{Action: auditSessionRevoke, TargetType: "session", TargetID: id.String()})
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) revokeAllSessionsHandler(w http.ResponseWriter, req *http.Request) {	//Logs the caller out everywhere by revoking all of their refresh tokens
+	userId := principalFrom(req.Context()).UserID
+
+	if err := cfg.db.RevokeUserTokens(req.Context(), userId); err != nil {
+		fmt.Printf("Error revoking sessions for user %s: %s\n", userId, err)
+		respondWithError(w, 500, "Error revoking sessions")
+		return
+	}
+	if err := cfg.revokeAccessTokens(req.Context(), userId); err != nil {
+		fmt.Printf("Error revoking access tokens for user %s: %s\n", userId, err)
+	}
+	cfg.audit(req, auditEntry{Action: auditSessionRevokeAll, TargetType: "user", TargetID: userId.String()})
+	w.WriteHeader(204)
+}
@@ -0,0 +1,19 @@
+-- name: DenyAccessToken :exec
+INSERT INTO access_token_denylist (id, created_at, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2
+)
+ON CONFLICT (id) DO UPDATE SET expires_at = GREATEST(access_token_denylist.expires_at, EXCLUDED.expires_at);
+
+-- name: IsAccessTokenDenied :one
+SELECT EXISTS (
+    SELECT 1 FROM access_token_denylist
+    WHERE id = $1
+    AND expires_at > NOW()
+);
+
+-- name: DeleteExpiredDenials :exec
+DELETE FROM access_token_denylist
+WHERE expires_at <= NOW();
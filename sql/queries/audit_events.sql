@@ -0,0 +1,26 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (id, created_at, action, outcome, actor_id, target_type, target_id, ip_address, user_agent, details)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9
+);
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE (sqlc.narg('action')::text IS NULL OR action = sqlc.narg('action'))
+AND (sqlc.narg('outcome')::text IS NULL OR outcome = sqlc.narg('outcome'))
+AND (sqlc.narg('actor_id')::uuid IS NULL OR actor_id = sqlc.narg('actor_id'))
+AND (sqlc.narg('target_id')::text IS NULL OR target_id = sqlc.narg('target_id'))
+AND (sqlc.narg('since')::timestamptz IS NULL OR created_at >= sqlc.narg('since'))
+AND (sqlc.narg('until')::timestamptz IS NULL OR created_at < sqlc.narg('until'))
+AND (sqlc.narg('before_created_at')::timestamptz IS NULL OR (created_at, id) < (sqlc.narg('before_created_at'), sqlc.narg('before_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
@@ -10,18 +10,24 @@ VALUES (
 RETURNING *;
 
 -- name: GetAllChirps :many
-SELECT * FROM chirps
-ORDER BY created_at ASC;
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deleted_at IS NULL
+ORDER BY chirps.created_at ASC;
 
 -- name: GetSingleChirp :one
-SELECT * FROM chirps
-WHERE id = $1;
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1
+AND users.deleted_at IS NULL;
 
 -- name: DeleteChirp :exec
 DELETE FROM chirps
 WHERE id = $1;
 
 -- name: GetChirpsFromAuthor :many
-SELECT * FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC;
\ No newline at end of file
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.user_id = $1
+AND users.deleted_at IS NULL
+ORDER BY chirps.created_at ASC;
\ No newline at end of file
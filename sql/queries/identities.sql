@@ -0,0 +1,19 @@
+-- name: CreateIdentity :one
+INSERT INTO identities (id, created_at, updated_at, user_id, issuer, subject, email)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5
+)
+RETURNING *;
+
+-- name: GetUserFromIdentity :one
+SELECT users.* FROM users
+INNER JOIN identities
+ON identities.user_id = users.id
+WHERE identities.issuer = $1
+AND identities.subject = $2;
@@ -0,0 +1,12 @@
+-- name: UseMagicLink :execrows
+INSERT INTO used_magic_links (id, used_at, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2
+)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: DeleteExpiredMagicLinks :exec
+DELETE FROM used_magic_links
+WHERE expires_at <= NOW();
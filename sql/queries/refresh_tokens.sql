@@ -1,27 +1,81 @@
 -- name: CreateToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at)
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, session_created_at, last_used_at, user_agent, ip_address, name)
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
     $3,
-    NULL
+    NULL,
+    $4,
+    $5,
+    NOW(),
+    $6,
+    $7,
+    $8
 )
 RETURNING *;
 
 -- name: GetToken :one
 SELECT * FROM refresh_tokens
-WHERE token = $1
+WHERE token_hash = $1
 AND revoked_at IS NULL;
 
+-- name: LookupToken :one
+SELECT * FROM refresh_tokens
+WHERE token_hash = $1;
+
 -- name: RevokeToken :exec
 UPDATE refresh_tokens
 SET revoked_at = $1, updated_at = NOW()
-WHERE token = $2;
+WHERE token_hash = $2;
+
+-- name: RevokeActiveToken :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE token_hash = $1
+AND revoked_at IS NULL;
+
+-- name: RevokeTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1
+AND revoked_at IS NULL;
+
+-- name: RevokeUserTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL;
+
+-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+AND family_id = $2
+AND revoked_at IS NULL;
+
+-- name: RenameUserSession :execrows
+UPDATE refresh_tokens
+SET name = $1, updated_at = NOW()
+WHERE user_id = $2
+AND family_id = $3
+AND revoked_at IS NULL;
+
+-- name: ListActiveSessions :many
+SELECT * FROM refresh_tokens
+WHERE user_id = $1
+AND revoked_at IS NULL
+AND expires_at > NOW()
+ORDER BY last_used_at DESC;
 
 -- name: GetUserFromToken :one
 SELECT users.* FROM users
 INNER JOIN refresh_tokens
 ON refresh_tokens.user_id = users.id
-WHERE refresh_tokens.token = $1;
\ No newline at end of file
+WHERE refresh_tokens.token_hash = $1;
+
+-- name: ListSessionHistory :many
+SELECT DISTINCT ON (family_id) * FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY family_id, created_at DESC;
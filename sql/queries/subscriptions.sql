@@ -0,0 +1,45 @@
+-- name: GetSubscription :one
+SELECT * FROM subscriptions
+WHERE user_id = $1;
+
+-- name: ActivateSubscription :one
+INSERT INTO subscriptions (user_id, created_at, updated_at, plan, status, current_period_end)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    'active',
+    $3
+)
+ON CONFLICT (user_id) DO UPDATE
+SET plan = EXCLUDED.plan, status = 'active', current_period_end = EXCLUDED.current_period_end, updated_at = NOW()
+RETURNING *;
+
+-- name: SetSubscriptionStatus :one
+UPDATE subscriptions
+SET status = $1, current_period_end = $2, updated_at = NOW()
+WHERE user_id = $3
+RETURNING *;
+
+-- name: ExpireLapsedSubscriptions :many
+UPDATE subscriptions
+SET status = 'expired', updated_at = NOW()
+WHERE status <> 'expired'
+AND current_period_end <= NOW()
+RETURNING user_id;
+
+-- name: RecordSubscriptionEvent :exec
+INSERT INTO subscription_events (id, created_at, user_id, event, payload)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4
+);
+
+-- name: ListSubscriptionEvents :many
+SELECT * FROM subscription_events
+WHERE user_id = $1
+ORDER BY created_at DESC;
@@ -1,12 +1,11 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password, is_chirpy_red)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password)
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
-    $3,
-    false
+    $3
 )
 RETURNING *;
 
@@ -23,10 +22,104 @@ WHERE id = $1;
 
 -- name: UpdateUserInfo :exec
 UPDATE users
-SET email = $1, hashed_password = $2, updated_at = NOW()
+SET email = $1, hashed_password = $2, updated_at = NOW(),
+email_verified_at = CASE WHEN email = $1 THEN email_verified_at ELSE NULL END
 WHERE id = $3;
 
--- name: UpdateChirpyRed :exec
+-- name: SetTOTPSecret :exec
 UPDATE users
-SET is_chirpy_red = true, updated_at = NOW()
-WHERE id = $1;
\ No newline at end of file
+SET totp_secret = $1, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+WHERE id = $2;
+
+-- name: EnableTOTP :exec
+UPDATE users
+SET totp_enabled_at = NOW(), totp_last_step = $1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: DisableTOTP :exec
+UPDATE users
+SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1;
+
+-- name: UpdateTOTPLastStep :execrows
+UPDATE users
+SET totp_last_step = $1
+WHERE id = $2
+AND totp_last_step < $1;
+
+-- name: VerifyUserEmail :execrows
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1
+AND email = $2
+AND email_verified_at IS NULL;
+
+-- name: UpdatePassword :exec
+UPDATE users
+SET hashed_password = $1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: SetUserRole :execrows
+UPDATE users
+SET role = $1, token_version = token_version + 1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: BootstrapAdmin :execrows
+UPDATE users
+SET role = 'admin', token_version = token_version + 1, updated_at = NOW()
+WHERE email = $1
+AND email_verified_at IS NOT NULL
+AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin');
+
+-- name: GetUserTokenState :one
+SELECT token_version, banned_at, role FROM users
+WHERE id = $1;
+
+-- name: IncrementTokenVersion :exec
+UPDATE users
+SET token_version = token_version + 1, updated_at = NOW()
+WHERE id = $1;
+
+-- name: BanUser :execrows
+UPDATE users
+SET banned_at = NOW(), token_version = token_version + 1, updated_at = NOW()
+WHERE id = $1
+AND banned_at IS NULL;
+
+-- name: UnbanUser :execrows
+UPDATE users
+SET banned_at = NULL, updated_at = NOW()
+WHERE id = $1
+AND banned_at IS NOT NULL;
+
+-- name: SoftDeleteUser :execrows
+UPDATE users
+SET deleted_at = NOW(), token_version = token_version + 1, updated_at = NOW()
+WHERE id = $1
+AND deleted_at IS NULL;
+
+-- name: RestoreUser :execrows
+UPDATE users
+SET deleted_at = NULL, updated_at = NOW()
+WHERE id = $1
+AND deleted_at IS NOT NULL;
+
+-- name: PurgeDeletedUsers :many
+DELETE FROM users
+WHERE deleted_at < $1
+RETURNING id, avatar_key;
+
+-- name: GetUserFromHandle :one
+SELECT * FROM users
+WHERE lower(handle) = lower($1);
+
+-- name: UpdateProfile :one
+UPDATE users
+SET handle = $1, display_name = $2, bio = $3, location = $4, website = $5, updated_at = NOW()
+WHERE id = $6
+RETURNING *;
+
+-- name: SetAvatar :exec
+UPDATE users
+SET avatar_key = $1, updated_at = NOW()
+WHERE id = $2;
@@ -0,0 +1,58 @@
+-- name: EnqueueWebhookEvent :execrows
+INSERT INTO webhook_events (id, created_at, updated_at, event_id, event, payload, status, attempts, next_attempt_at)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3,
+    $4,
+    'pending',
+    0,
+    NOW()
+)
+ON CONFLICT (event_id) DO NOTHING;
+
+-- name: ClaimWebhookEvents :many
+UPDATE webhook_events
+SET attempts = attempts + 1, next_attempt_at = $1, updated_at = NOW()
+WHERE id IN (
+    SELECT id FROM webhook_events
+    WHERE status = 'pending'
+    AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at
+    LIMIT $2
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkWebhookEventProcessed :exec
+UPDATE webhook_events
+SET status = 'processed', processed_at = NOW(), last_error = NULL, updated_at = NOW()
+WHERE id = $1;
+
+-- name: RetryWebhookEvent :exec
+UPDATE webhook_events
+SET last_error = $1, next_attempt_at = $2, updated_at = NOW()
+WHERE id = $3;
+
+-- name: DeadLetterWebhookEvent :exec
+UPDATE webhook_events
+SET status = 'dead', last_error = $1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE id = $1;
+
+-- name: ListWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE status = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: ReplayWebhookEvent :execrows
+UPDATE webhook_events
+SET status = 'pending', attempts = 0, next_attempt_at = NOW(), last_error = NULL, processed_at = NULL, updated_at = NOW()
+WHERE id = $1
+AND status <> 'pending';
@@ -0,0 +1,11 @@
+-- +goose Up
+UPDATE refresh_tokens
+SET token = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+
+ALTER TABLE refresh_tokens
+RENAME COLUMN token TO token_hash;
+
+-- +goose Down
+-- Digests cannot be reversed, so every existing refresh token stays unusable after rolling back
+ALTER TABLE refresh_tokens
+RENAME COLUMN token_hash TO token;
@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+ADD session_created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+ADD last_used_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+ADD user_agent TEXT NOT NULL DEFAULT '',
+ADD ip_address TEXT NOT NULL DEFAULT '',
+ADD name TEXT NOT NULL DEFAULT '';
+
+UPDATE refresh_tokens
+SET session_created_at = created_at, last_used_at = updated_at;
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_user_id_idx;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN session_created_at,
+DROP COLUMN last_used_at,
+DROP COLUMN user_agent,
+DROP COLUMN ip_address,
+DROP COLUMN name;
@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE users
+ADD email_verified_at TIMESTAMPTZ;
+
+-- Accounts from before verification existed are trusted, so REQUIRE_VERIFIED_EMAIL doesn't lock them out
+UPDATE users
+SET email_verified_at = NOW();
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN email_verified_at;
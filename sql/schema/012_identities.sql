@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE identities (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    user_id UUID NOT NULL REFERENCES users(id)
+    ON DELETE CASCADE,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    UNIQUE (issuer, subject)
+);
+
+-- +goose Down
+DROP TABLE identities;
@@ -0,0 +1,19 @@
+-- +goose Up
+ALTER TABLE users
+ADD token_version INTEGER NOT NULL DEFAULT 0,
+ADD banned_at TIMESTAMPTZ;
+
+CREATE TABLE access_token_denylist (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX access_token_denylist_expires_at_idx ON access_token_denylist (expires_at);
+
+-- +goose Down
+DROP TABLE access_token_denylist;
+
+ALTER TABLE users
+DROP COLUMN banned_at,
+DROP COLUMN token_version;
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE webhook_nonces (
+    nonce TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX webhook_nonces_expires_at_idx ON webhook_nonces (expires_at);
+
+-- +goose Down
+DROP TABLE webhook_nonces;
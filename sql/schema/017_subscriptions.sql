@@ -0,0 +1,41 @@
+-- +goose Up
+CREATE TABLE subscriptions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('active', 'past_due', 'canceled', 'expired')),
+    current_period_end TIMESTAMPTZ
+);
+
+CREATE INDEX subscriptions_current_period_end_idx ON subscriptions (current_period_end)
+WHERE status <> 'expired';
+
+CREATE TABLE subscription_events (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL
+);
+
+CREATE INDEX subscription_events_user_id_idx ON subscription_events (user_id, created_at);
+
+INSERT INTO subscriptions (user_id, created_at, updated_at, plan, status, current_period_end)
+SELECT id, NOW(), NOW(), 'chirpy_red', 'active', NULL
+FROM users
+WHERE is_chirpy_red;
+
+ALTER TABLE users
+DROP COLUMN is_chirpy_red;
+
+-- +goose Down
+ALTER TABLE users
+ADD is_chirpy_red BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE users
+SET is_chirpy_red = true
+WHERE id IN (SELECT user_id FROM subscriptions WHERE status <> 'expired');
+
+DROP TABLE subscription_events;
+DROP TABLE subscriptions;
@@ -0,0 +1,31 @@
+-- +goose Up
+CREATE TABLE webhook_events (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    event_id TEXT NOT NULL UNIQUE,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('pending', 'processed', 'dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    last_error TEXT,
+    processed_at TIMESTAMPTZ
+);
+
+CREATE INDEX webhook_events_pending_idx ON webhook_events (next_attempt_at)
+WHERE status = 'pending';
+
+-- Replays are now caught by the unique event_id
+DROP TABLE webhook_nonces;
+
+-- +goose Down
+CREATE TABLE webhook_nonces (
+    nonce TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX webhook_nonces_expires_at_idx ON webhook_nonces (expires_at);
+
+DROP TABLE webhook_events;
@@ -0,0 +1,21 @@
+-- +goose Up
+ALTER TABLE users
+ADD handle TEXT,
+ADD display_name TEXT NOT NULL DEFAULT '',
+ADD bio TEXT NOT NULL DEFAULT '',
+ADD location TEXT NOT NULL DEFAULT '',
+ADD website TEXT NOT NULL DEFAULT '',
+ADD avatar_key TEXT;
+
+CREATE UNIQUE INDEX users_handle_key ON users (lower(handle));
+
+-- +goose Down
+DROP INDEX users_handle_key;
+
+ALTER TABLE users
+DROP COLUMN handle,
+DROP COLUMN display_name,
+DROP COLUMN bio,
+DROP COLUMN location,
+DROP COLUMN website,
+DROP COLUMN avatar_key;
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE used_magic_links (
+    id UUID PRIMARY KEY,
+    used_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX used_magic_links_expires_at_idx ON used_magic_links (expires_at);
+
+-- +goose Down
+DROP TABLE used_magic_links;
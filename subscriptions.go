@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+const (
+	planChirpyRed = "chirpy_red"
+
+	subscriptionActive = "active"
+	subscriptionPastDue = "past_due"	//Payment failed, features stay on until the period ends in case it's retried
+	subscriptionCanceled = "canceled"	//Won't renew, features stay on until the period ends
+	subscriptionExpired = "expired"
+)
+
+type Subscription struct {
+	Plan string `json:"plan"`
+	Status string `json:"status"`
+	Active bool `json:"active"`	//Whether the plan's features are available right now
+	CurrentPeriodEnd *time.Time `json:"current_period_end"`	//Null for subscriptions without an end date
+	RenewsAt *time.Time `json:"renews_at,omitempty"`	//Only set while the subscription will renew
+}
+
+func toSubscription(s database.Subscription, now time.Time) *Subscription {
+	sub := &Subscription{
+		Plan: s.Plan,
+		Status: s.Status,
+		Active: subscriptionEntitled(s, now),
+	}
+	if s.CurrentPeriodEnd.Valid {
+		end := s.CurrentPeriodEnd.Time
+		sub.CurrentPeriodEnd = &end
+		if s.Status == subscriptionActive {
+			sub.RenewsAt = &end
+		}
+	}
+	return sub
+}
+
+func subscriptionEntitled(s database.Subscription, now time.Time) bool {	//Checked against the period end too, so features stop on time even if the expiry job is behind
+	if s.Status == subscriptionExpired {
+		return false
+	}
+	return !s.CurrentPeriodEnd.Valid || now.Before(s.CurrentPeriodEnd.Time)
+}
+
+func (cfg *apiConfig) userSubscription(ctx context.Context, userID uuid.UUID) *Subscription {	//Nil when the user has never subscribed
+	s, err := cfg.db.GetSubscription(ctx, userID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			fmt.Printf("Error finding subscription for user %s: %s\n", userID, err)
+		}
+		return nil
+	}
+	return toSubscription(s, time.Now())
+}
+
+var (
+	errUnknownEvent = errors.New("unknown subscription event")
+	errNoSubscription = errors.New("user has no subscription")
+)
+
+func (cfg *apiConfig) applySubscriptionEvent(ctx context.Context, event string, userID uuid.UUID, plan string, periodEnd *time.Time) error {	//Moves a subscription through its lifecycle in response to a Polka event
+	end := sql.NullTime{}
+	if periodEnd != nil {
+		end = sql.NullTime{Time: *periodEnd, Valid: true}
+	}
+	if plan == "" {
+		plan = planChirpyRed
+	}
+
+	switch event {
+	case "user.upgraded", "user.renewed":
+		_, err := cfg.db.ActivateSubscription(ctx, database.ActivateSubscriptionParams{
+			UserID: userID,
+			Plan: plan,
+			CurrentPeriodEnd: end,
+		})
+		return err
+	case "user.payment_failed", "user.canceled", "user.downgraded":
+	default:
+		return errUnknownEvent
+	}
+
+	current, err := cfg.db.GetSubscription(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errNoSubscription
+	}
+	if err != nil {
+		return err
+	}
+
+	status, end := subscriptionPastDue, current.CurrentPeriodEnd
+	switch event {
+	case "user.canceled":
+		status = subscriptionCanceled
+		if !end.Valid {	//Nothing left to run out, so it ends now
+			end = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	case "user.downgraded":	//Takes effect at once
+		status = subscriptionExpired
+		end = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	_, err = cfg.db.SetSubscriptionStatus(ctx, database.SetSubscriptionStatusParams{
+		Status: status,
+		CurrentPeriodEnd: end,
+		UserID: userID,
+	})
+	return err
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+func TestToSubscription(t *testing.T) {
+	now := time.Now()
+	future := sql.NullTime{Time: now.Add(24 * time.Hour), Valid: true}
+	past := sql.NullTime{Time: now.Add(-time.Hour), Valid: true}
+
+	tests := []struct {
+		name string
+		status string
+		end sql.NullTime
+		active bool
+		renews bool
+	}{
+		{"active", subscriptionActive, future, true, true},
+		{"no end date", subscriptionActive, sql.NullTime{}, true, false},
+		{"canceled keeps paid period", subscriptionCanceled, future, true, false},
+		{"past due keeps paid period", subscriptionPastDue, future, true, false},
+		{"lapsed before the job ran", subscriptionActive, past, false, true},
+		{"expired", subscriptionExpired, future, false, false},
+	}
+	for _, tc := range tests {
+		sub := toSubscription(database.Subscription{Plan: planChirpyRed, Status: tc.status, CurrentPeriodEnd: tc.end}, now)
+		if sub.Active != tc.active {
+			t.Errorf("%s: got active %v, wanted %v", tc.name, sub.Active, tc.active)
+		}
+		if (sub.RenewsAt != nil) != tc.renews {
+			t.Errorf("%s: got renews_at %v", tc.name, sub.RenewsAt)
+		}
+	}
+}
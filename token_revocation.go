@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/database"
+	"github.com/jms-guy/chirpy/internal/revocation"
+)
+
+type dbRevocationStore struct {	//Keeps revocations in Postgres so every instance sees them
+	db *database.Queries
+}
+
+func (s dbRevocationStore) UserState(ctx context.Context, userID uuid.UUID) (revocation.UserState, error) {
+	row, err := s.db.GetUserTokenState(ctx, userID)
+	if err != nil {
+		return revocation.UserState{}, err
+	}
+	return revocation.UserState{TokenVersion: row.TokenVersion, Banned: row.BannedAt.Valid, Role: row.Role}, nil
+}
+
+func (s dbRevocationStore) IsDenied(ctx context.Context, id uuid.UUID) (bool, error) {
+	return s.db.IsAccessTokenDenied(ctx, id)
+}
+
+func (s dbRevocationStore) Deny(ctx context.Context, id uuid.UUID, until time.Time) error {
+	return s.db.DenyAccessToken(ctx, database.DenyAccessTokenParams{ID: id, ExpiresAt: until})
+}
+
+func (cfg *apiConfig) denySession(ctx context.Context, familyID uuid.UUID) {	//Voids access tokens already issued from a session whose refresh tokens were just revoked
+	if err := cfg.revocations.Deny(ctx, familyID, time.Now().Add(accessTokenTTL)); err != nil {
+		fmt.Printf("Error denying access tokens for session %s: %s\n", familyID, err)
+	}
+}
+
+func (cfg *apiConfig) revokeAccessTokens(ctx context.Context, userID uuid.UUID) error {	//Voids every access token the user holds by bumping their token version
+	if err := cfg.db.IncrementTokenVersion(ctx, userID); err != nil {
+		return err
+	}
+	cfg.revocations.Forget(userID)
+	return nil
+}
+
+func (cfg *apiConfig) banUserHandler(w http.ResponseWriter, req *http.Request) {	//Suspends an account, ending all of its sessions and access tokens at once
+	id, err := uuid.Parse(req.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	if id == principalFrom(req.Context()).UserID {
+		respondWithError(w, 400, "You can't ban yourself")
+		return
+	}
+	if _, err := cfg.db.GetUserFromID(req.Context(), id); err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	banned, err := cfg.db.BanUser(req.Context(), id)	//Also bumps the token version
+	if err != nil {
+		respondWithError(w, 500, "Error banning user")
+		return
+	}
+	if banned == 0 {
+		respondWithError(w, 409, "User is already banned")
+		return
+	}
+	cfg.revocations.Forget(id)
+	if err := cfg.db.RevokeUserTokens(req.Context(), id); err != nil {
+		fmt.Printf("Error revoking sessions for banned user %s: %s\n", id, err)
+	}
+	cfg.audit(req, auditEntry{Action: auditAdminBan, TargetType: "user", TargetID: id.String()})
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) unbanUserHandler(w http.ResponseWriter, req *http.Request) {	//Lifts a ban. The user has to log in again as their old sessions stay revoked
+	id, err := uuid.Parse(req.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	unbanned, err := cfg.db.UnbanUser(req.Context(), id)
+	if err != nil {
+		respondWithError(w, 500, "Error unbanning user")
+		return
+	}
+	if unbanned == 0 {
+		respondWithError(w, 404, "No banned user with that id")
+		return
+	}
+	cfg.revocations.Forget(id)
+	cfg.audit(req, auditEntry{Action: auditAdminUnban, TargetType: "user", TargetID: id.String()})
+	w.WriteHeader(204)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+const recoveryCodeCount = 10
+
+func (cfg *apiConfig) enrollTOTPHandler(w http.ResponseWriter, req *http.Request) {	//Starts TOTP enrollment, returning a new secret that must be confirmed before it is enforced
+	userId := principalFrom(req.Context()).UserID
+
+	user, err := cfg.db.GetUserFromID(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 500, "Error finding user")
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, 500, "Error creating secret")
+		return
+	}
+	if err := cfg.db.SetTOTPSecret(req.Context(), database.SetTOTPSecretParams{
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+		ID: user.ID,
+	}); err != nil {
+		fmt.Printf("Error saving TOTP secret for user %s: %s\n", user.ID, err)
+		respondWithError(w, 500, "Error saving secret")
+		return
+	}
+
+	respondWithJSON(w, 200, struct {
+		Secret string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`	//QR code payload for authenticator apps
+	}{
+		Secret: secret,
+		OTPAuthURI: auth.TOTPURI(secret, "Chirpy", user.Email),
+	})
+}
+
+func (cfg *apiConfig) confirmTOTPHandler(w http.ResponseWriter, req *http.Request) {	//Enables TOTP once the user proves their authenticator works, and issues recovery codes
+	type httpRequest struct {
+		Code string `json:"code"`
+	}
+
+	userId := principalFrom(req.Context()).UserID
+
+	request := httpRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, 400, "Invalid JSON payload")
+		return
+	}
+
+	user, err := cfg.db.GetUserFromID(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 500, "Error finding user")
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+	if !user.TotpSecret.Valid {
+		respondWithError(w, 400, "Two-factor enrollment has not been started")
+		return
+	}
+
+	step, err := auth.ValidateTOTP(user.TotpSecret.String, request.Code, time.Now(), user.TotpLastStep)
+	if err != nil {
+		respondWithError(w, 401, "Invalid code")
+		return
+	}
+
+	codes := []string{}
+	err = cfg.inTx(req.Context(), func(q *database.Queries) error {	//Recovery codes and 2FA come into effect together, or not at all
+		codes, err = replaceRecoveryCodes(req.Context(), q, user.ID)
+		if err != nil {
+			return err
+		}
+		return q.EnableTOTP(req.Context(), database.EnableTOTPParams{
+			TotpLastStep: step,
+			ID: user.ID,
+		})
+	})
+	if err != nil {
+		fmt.Printf("Error enabling two-factor authentication for user %s: %s\n", user.ID, err)
+		respondWithError(w, 500, "Error enabling two-factor authentication")
+		return
+	}
+	cfg.audit(req, auditEntry{Action: auditTwoFactorEnable, TargetType: "user", TargetID: user.ID.String()})
+
+	respondWithJSON(w, 200, struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	})
+}
+
+func (cfg *apiConfig) disableTOTPHandler(w http.ResponseWriter, req *http.Request) {	//Turns TOTP off, requiring the password and a current code or recovery code
+	type httpRequest struct {
+		Password string `json:"password"`
+		Code string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	userId := principalFrom(req.Context()).UserID
+
+	request := httpRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, 400, "Invalid JSON payload")
+		return
+	}
+
+	user, err := cfg.db.GetUserFromID(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 500, "Error finding user")
+		return
+	}
+	if !user.TotpEnabledAt.Valid {
+		respondWithError(w, 400, "Two-factor authentication is not enabled")
+		return
+	}
+	if !cfg.confirmPassword(w, req, auditTwoFactorDisable, user, request.Password) {
+		return
+	}
+	if !cfg.checkSecondFactor(req, user, request.Code, request.RecoveryCode) {
+		cfg.failLogin(req, auditTwoFactorDisable, strings.ToLower(user.Email), user.ID, "bad_code")
+		respondWithError(w, 401, "Invalid code")
+		return
+	}
+
+	err = cfg.inTx(req.Context(), func(q *database.Queries) error {
+		if err := q.DisableTOTP(req.Context(), user.ID); err != nil {
+			return err
+		}
+		return q.DeleteRecoveryCodes(req.Context(), user.ID)
+	})
+	if err != nil {
+		fmt.Printf("Error disabling two-factor authentication for user %s: %s\n", user.ID, err)
+		respondWithError(w, 500, "Error disabling two-factor authentication")
+		return
+	}
+	cfg.audit(req, auditEntry{Action: auditTwoFactorDisable, TargetType: "user", TargetID: user.ID.String()})
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) loginTOTPHandler(w http.ResponseWriter, req *http.Request) {	//Second login step, exchanges a challenge token and TOTP or recovery code for access and refresh tokens
+	type httpRequest struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		UseCookies bool `json:"use_cookies"`
+	}
+
+	request := httpRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		fmt.Printf("Error decoding request body: %s", err)
+		respondWithError(w, 400, "Invalid JSON payload")
+		return
+	}
+
+	userId, err := auth.ValidateChallengeJWT(request.ChallengeToken, cfg.keys)
+	if err != nil {
+		respondWithError(w, 401, "Challenge token is invalid or expired")
+		return
+	}
+
+	user, err := cfg.db.GetUserFromID(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 401, "Challenge token is invalid or expired")
+		return
+	}
+	if !user.TotpEnabledAt.Valid {	//2FA was turned off after the challenge was issued
+		respondWithError(w, 401, "Challenge token is invalid or expired")
+		return
+	}
+
+	accountKey := strings.ToLower(user.Email)	//Code guesses count against the same limits as password guesses
+	if !cfg.allowLogin(w, req, accountKey) {
+		return
+	}
+	if !cfg.checkSecondFactor(req, user, request.Code, request.RecoveryCode) {
+		cfg.failLogin(req, auditLogin2FA, accountKey, user.ID, "bad_code")
+		respondWithError(w, 401, "Invalid code")
+		return
+	}
+
+	cfg.accountLimiter.Reset(accountKey)
+	cfg.completeLogin(w, req, auditLogin2FA, user, request.UseCookies)
+}
+
+func (cfg *apiConfig) checkSecondFactor(req *http.Request, user database.User, code, recoveryCode string) bool {	//Checks a TOTP code, or failing that a recovery code, consuming whichever was used
+	if code != "" {
+		step, err := auth.ValidateTOTP(user.TotpSecret.String, code, time.Now(), user.TotpLastStep)
+		if err != nil {
+			return false
+		}
+		updated, err := cfg.db.UpdateTOTPLastStep(req.Context(), database.UpdateTOTPLastStepParams{
+			TotpLastStep: step,
+			ID: user.ID,
+		})
+		return err == nil && updated == 1	//Zero rows means a concurrent request already used this code
+	}
+	if recoveryCode != "" {
+		used, err := cfg.db.UseRecoveryCode(req.Context(), database.UseRecoveryCodeParams{
+			UserID: user.ID,
+			CodeHash: auth.HashRecoveryCode(recoveryCode),
+		})
+		return err == nil && used == 1
+	}
+	return false
+}
+
+func replaceRecoveryCodes(ctx context.Context, q *database.Queries, userID uuid.UUID) ([]string, error) {	//Swaps a user's recovery codes for a fresh set, returning the raw codes to show once. Run it in a transaction so a failure can't leave the set half replaced
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if err := q.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{
+			ID: uuid.New(),
+			UserID: userID,
+			CodeHash: auth.HashRecoveryCode(code),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
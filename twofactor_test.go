@@ -0,0 +1,47 @@
+package main
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+func TestDisableTOTPPasswordThrottled(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB(t, cfg)
+	hash, err := auth.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := db.addUser(database.User{
+		Email: "user@example.com",
+		HashedPassword: hash,
+		TotpSecret: sql.NullString{String: "JBSWY3DPEHPK3PXP", Valid: true},
+		TotpEnabledAt: sql.NullTime{Time: time.Now(), Valid: true},
+	})
+	token, err := auth.MakeJWT(auth.AccessToken{UserID: user.ID, Role: user.Role}, cfg.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := cfg.requireAuth("", cfg.disableTOTPHandler)
+
+	codes := []int{}
+	for range 7 {
+		req := httptest.NewRequest("POST", "/api/users/me/2fa/disable", strings.NewReader(`{"password":"guess","code":"000000"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	if codes[0] != 401 || codes[len(codes)-1] != 429 {
+		t.Errorf("wrong passwords got statuses %v, wanted 401 and then 429", codes)
+	}
+	if !db.user(user.ID).TotpEnabledAt.Valid {
+		t.Error("2FA disabled without the password")
+	}
+}
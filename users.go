@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/avatar"
+	"github.com/jms-guy/chirpy/internal/database"
+	"github.com/jms-guy/chirpy/internal/profile"
+	"github.com/lib/pq"
+)
+
+func (cfg *apiConfig) privateUser(ctx context.Context, u database.User) PrivateUser {
+	return PrivateUser{
+		ID: u.ID,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+		Email: u.Email,
+		EmailVerified: u.EmailVerifiedAt.Valid,
+		Role: u.Role,
+		TwoFactorEnabled: u.TotpEnabledAt.Valid,
+		Subscription: cfg.userSubscription(ctx, u.ID),
+		Profile: cfg.userProfile(u),
+	}
+}
+
+func (cfg *apiConfig) publicUser(ctx context.Context, u database.User) PublicUser {
+	return toPublicUser(u, cfg.userProfile(u), cfg.userSubscription(ctx, u.ID))
+}
+
+func toPublicUser(u database.User, prof Profile, sub *Subscription) PublicUser {	//Leaves out the email address and anything else only the user should see
+	return PublicUser{
+		ID: u.ID,
+		CreatedAt: u.CreatedAt,
+		Profile: prof,
+		ChirpyRed: sub != nil && sub.Active,
+	}
+}
+
+func (cfg *apiConfig) userProfile(u database.User) Profile {
+	prof := Profile{
+		Handle: u.Handle.String,
+		DisplayName: u.DisplayName,
+		Bio: u.Bio,
+		Location: u.Location,
+		Website: u.Website,
+	}
+	if u.AvatarKey.Valid && cfg.avatars != nil {
+		prof.Avatar = map[string]string{}
+		for _, size := range avatar.Sizes {
+			prof.Avatar[size.Name] = cfg.avatars.URL(avatarFile(u.AvatarKey.String, size))
+		}
+	}
+	return prof
+}
+
+func (cfg *apiConfig) getMeHandler(w http.ResponseWriter, req *http.Request) {	//Returns the caller's own account, including private fields
+	userId := principalFrom(req.Context()).UserID
+
+	user, err := cfg.db.GetUserFromID(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	respondWithJSON(w, 200, cfg.privateUser(req.Context(), user))
+}
+
+func (cfg *apiConfig) getUserHandler(w http.ResponseWriter, req *http.Request) {	//Returns the public profile of any user, looked up by id or by handle with or without the @
+	var user database.User
+	var err error
+	ref := req.PathValue("userId")
+	if id, parseErr := uuid.Parse(ref); parseErr == nil {
+		user, err = cfg.db.GetUserFromID(req.Context(), id)
+	} else {
+		user, err = cfg.db.GetUserFromHandle(req.Context(), profile.NormalizeHandle(ref))
+	}
+	if err != nil || user.DeletedAt.Valid {	//Accounts waiting to be purged are already gone as far as others can tell
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	respondWithJSON(w, 200, cfg.publicUser(req.Context(), user))
+}
+
+func (cfg *apiConfig) updateProfileHandler(w http.ResponseWriter, req *http.Request) {	//Changes the caller's profile. Fields left out of the request keep their current value, an empty string clears one
+	type httpRequest struct {
+		Handle *string `json:"handle"`
+		DisplayName *string `json:"display_name"`
+		Bio *string `json:"bio"`
+		Location *string `json:"location"`
+		Website *string `json:"website"`
+	}
+
+	userId := principalFrom(req.Context()).UserID
+
+	request := httpRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		respondWithError(w, 400, "Invalid JSON payload")
+		return
+	}
+
+	user, err := cfg.db.GetUserFromID(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 500, "Error finding user")
+		return
+	}
+	fields := profile.Fields{
+		Handle: user.Handle.String,
+		DisplayName: user.DisplayName,
+		Bio: user.Bio,
+		Location: user.Location,
+		Website: user.Website,
+	}
+	changed := []string{}
+	for _, f := range []struct {
+		name string
+		value *string
+		field *string
+	}{
+		{"handle", request.Handle, &fields.Handle},
+		{"display_name", request.DisplayName, &fields.DisplayName},
+		{"bio", request.Bio, &fields.Bio},
+		{"location", request.Location, &fields.Location},
+		{"website", request.Website, &fields.Website},
+	} {
+		if f.value != nil {
+			*f.field = *f.value
+			changed = append(changed, f.name)
+		}
+	}
+	fields = fields.Normalize()
+	if err := fields.Validate(); err != nil {
+		respondWithFieldError(w, 400, err)
+		return
+	}
+
+	updated, err := cfg.db.UpdateProfile(req.Context(), database.UpdateProfileParams{
+		Handle: sql.NullString{String: fields.Handle, Valid: fields.Handle != ""},
+		DisplayName: fields.DisplayName,
+		Bio: fields.Bio,
+		Location: fields.Location,
+		Website: fields.Website,
+		ID: user.ID,
+	})
+	if isUniqueViolation(err) {	//On the lower(handle) index
+		respondWithFieldError(w, 409, &profile.FieldError{Field: "handle", Message: "That handle is already taken"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error updating profile for user %s: %s\n", user.ID, err)
+		respondWithError(w, 500, "Error updating profile")
+		return
+	}
+	details := map[string]any{"fields": changed}
+	if updated.Handle != user.Handle {	//Handle changes are worth tracing, an old handle can be taken by someone else
+		details["old_handle"], details["new_handle"] = user.Handle.String, updated.Handle.String
+	}
+	cfg.audit(req, auditEntry{Action: auditProfileUpdate, TargetType: "user", TargetID: user.ID.String(), Details: details})
+	respondWithJSON(w, 200, cfg.privateUser(req.Context(), updated))
+}
+
+func respondWithFieldError(w http.ResponseWriter, code int, err error) {	//Names the offending field for profile.FieldError, for clients to highlight it
+	var fieldErr *profile.FieldError
+	if !errors.As(err, &fieldErr) {
+		respondWithError(w, code, err.Error())
+		return
+	}
+	respondWithJSON(w, code, struct {
+		Error string `json:"error"`
+		Field string `json:"field"`
+	}{
+		Error: fieldErr.Message,
+		Field: fieldErr.Field,
+	})
+}
+
+func avatarFile(key string, size avatar.Size) string {	//Storage key of one thumbnail, key being the prefix saved on the user
+	return key + "/" + size.Name + ".png"
+}
+
+func (cfg *apiConfig) uploadAvatarHandler(w http.ResponseWriter, req *http.Request) {	//Takes an image as the raw request body and stores it as the caller's avatar, in every thumbnail size
+	userId := principalFrom(req.Context()).UserID
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, req.Body, avatar.MaxUploadBytes))
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		respondWithError(w, 413, fmt.Sprintf("Avatar must be at most %d MB", avatar.MaxUploadBytes>>20))
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, "Error reading upload")
+		return
+	}
+
+	thumbs, err := avatar.Process(data)
+	switch {
+	case errors.Is(err, avatar.ErrUnsupportedType):
+		respondWithError(w, 415, err.Error())
+		return
+	case errors.Is(err, avatar.ErrTooLarge):
+		respondWithError(w, 413, err.Error())
+		return
+	case err != nil:
+		respondWithError(w, 400, "Could not read image")
+		return
+	}
+
+	user, err := cfg.db.GetUserFromID(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 500, "Error finding user")
+		return
+	}
+
+	key := user.ID.String() + "/" + uuid.NewString()	//A new key per upload, so cached copies of the old avatar never show up under the new URL
+	for _, thumb := range thumbs {
+		if err := cfg.avatars.Put(req.Context(), avatarFile(key, thumb.Size), bytes.NewReader(thumb.PNG)); err != nil {
+			fmt.Printf("Error storing avatar for user %s: %s\n", user.ID, err)
+			cfg.deleteAvatar(context.WithoutCancel(req.Context()), key)
+			respondWithError(w, 500, "Error storing avatar")
+			return
+		}
+	}
+	if err := cfg.db.SetAvatar(req.Context(), database.SetAvatarParams{
+		AvatarKey: sql.NullString{String: key, Valid: true},
+		ID: user.ID,
+	}); err != nil {
+		cfg.deleteAvatar(context.WithoutCancel(req.Context()), key)
+		respondWithError(w, 500, "Error saving avatar")
+		return
+	}
+	if user.AvatarKey.Valid {
+		cfg.deleteAvatar(req.Context(), user.AvatarKey.String)
+	}
+
+	cfg.audit(req, auditEntry{Action: auditAvatarUpdate, TargetType: "user", TargetID: user.ID.String()})
+	user.AvatarKey = sql.NullString{String: key, Valid: true}
+	respondWithJSON(w, 200, cfg.privateUser(req.Context(), user))
+}
+
+func (cfg *apiConfig) deleteAvatarHandler(w http.ResponseWriter, req *http.Request) {	//Removes the caller's avatar
+	userId := principalFrom(req.Context()).UserID
+
+	user, err := cfg.db.GetUserFromID(req.Context(), userId)
+	if err != nil {
+		respondWithError(w, 500, "Error finding user")
+		return
+	}
+	if !user.AvatarKey.Valid {
+		respondWithError(w, 404, "No avatar to delete")
+		return
+	}
+	if err := cfg.db.SetAvatar(req.Context(), database.SetAvatarParams{ID: user.ID}); err != nil {
+		respondWithError(w, 500, "Error deleting avatar")
+		return
+	}
+	cfg.deleteAvatar(req.Context(), user.AvatarKey.String)
+	cfg.audit(req, auditEntry{Action: auditAvatarDelete, TargetType: "user", TargetID: user.ID.String()})
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) deleteAvatar(ctx context.Context, key string) {	//Removes every thumbnail stored under key. Failures only leave orphaned files, so they are logged
+	for _, size := range avatar.Sizes {
+		if err := cfg.avatars.Delete(ctx, avatarFile(key, size)); err != nil {
+			fmt.Printf("Error deleting avatar %s: %s\n", key, err)
+		}
+	}
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/auth"
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+func TestPublicUserHidesPrivateFields(t *testing.T) {
+	u := database.User{ID: uuid.New(), CreatedAt: time.Now(), Email: "user@example.com", HashedPassword: "hash"}
+	body, err := json.Marshal(toPublicUser(u, Profile{Handle: "alice"}, &Subscription{Plan: planChirpyRed, Status: subscriptionActive, Active: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"email", "token", "hash", "role", "subscription"} {
+		if strings.Contains(string(body), field) {
+			t.Errorf("public user contains %q: %s", field, body)
+		}
+	}
+	if !strings.Contains(string(body), `"handle":"alice"`) {
+		t.Errorf("public user missing profile: %s", body)
+	}
+	if !strings.Contains(string(body), `"is_chirpy_red":true`) {
+		t.Errorf("active subscriber not marked: %s", body)
+	}
+
+	if toPublicUser(u, Profile{}, nil).ChirpyRed || toPublicUser(u, Profile{}, &Subscription{Status: subscriptionExpired}).ChirpyRed {
+		t.Error("non subscriber marked Chirpy Red")
+	}
+}
+
+func TestLoginResponseIsFlat(t *testing.T) {
+	body, err := json.Marshal(LoginResponse{PrivateUser: PrivateUser{Email: "user@example.com"}, Token: "access"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]any{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["email"] != "user@example.com" || fields["token"] != "access" {
+		t.Errorf("unexpected login response %s", body)
+	}
+	if _, ok := fields["refresh_token"]; ok {
+		t.Errorf("empty refresh token should be left out: %s", body)
+	}
+}
+
+func TestUpdateUserCurrentPasswordThrottled(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB(t, cfg)
+	hash, err := auth.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := db.addUser(database.User{Email: "user@example.com", HashedPassword: hash})
+	token, err := auth.MakeJWT(auth.AccessToken{UserID: user.ID, Role: user.Role}, cfg.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := cfg.requireAuth("", cfg.updateUserHandler)
+
+	codes := []int{}
+	for range 7 {
+		req := httptest.NewRequest("PUT", "/api/users", strings.NewReader(`{"current_password":"guess","email":"thief@example.com"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	if codes[0] != 401 || codes[len(codes)-1] != 429 {
+		t.Errorf("wrong current passwords got statuses %v, wanted 401 and then 429", codes)
+	}
+	if db.user(user.ID).Email != user.Email {
+		t.Error("email changed without the current password")
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+const (
+	webhookPending = "pending"
+	webhookProcessed = "processed"
+	webhookDead = "dead"	//Gave up after webhookMaxAttempts or a permanent error, waiting for an admin to replay it
+
+	webhookBatchSize = 10
+	webhookLease = 5 * time.Minute	//A claimed event is retried after this if its worker dies mid-way
+	webhookMaxAttempts = 8
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff = 1 * time.Hour
+)
+
+type permanentError struct {	//An event that will never succeed however often it's retried
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+func webhookBackoff(attempts int32) time.Duration {	//Delay before the next try, doubling with each failed attempt
+	delay := webhookBaseBackoff
+	for i := int32(1); i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxBackoff)
+}
+
+func (cfg *apiConfig) wakeWebhookWorker() {
+	select {
+	case cfg.webhookWake <- struct{}{}:
+	default:	//Already awake
+	}
+}
+
+func (cfg *apiConfig) runWebhookWorker(poll time.Duration) {	//Processes queued webhook events, polling for retries that come due
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-cfg.webhookWake:
+		}
+		cfg.processWebhookEvents(context.Background())
+	}
+}
+
+func (cfg *apiConfig) processWebhookEvents(ctx context.Context) {	//Works through due events until none are left
+	for {
+		events, err := cfg.db.ClaimWebhookEvents(ctx, database.ClaimWebhookEventsParams{
+			NextAttemptAt: time.Now().Add(webhookLease),
+			Limit: webhookBatchSize,
+		})
+		if err != nil {
+			fmt.Printf("Error claiming webhook events: %s\n", err)
+			return
+		}
+		for _, event := range events {
+			cfg.finishWebhookEvent(ctx, event, cfg.handleWebhookEvent(ctx, event))
+		}
+		if len(events) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+func (cfg *apiConfig) handleWebhookEvent(ctx context.Context, event database.WebhookEvent) error {	//Applies one Polka event. Errors are retried unless wrapped in permanentError
+	payload := struct {
+		Data struct {
+			UserID string `json:"user_id"`
+			Plan string `json:"plan"`
+			CurrentPeriodEnd *time.Time `json:"current_period_end"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+		return permanentError{fmt.Errorf("error decoding payload: %w", err)}
+	}
+	userID, err := uuid.Parse(payload.Data.UserID)
+	if err != nil {
+		return permanentError{fmt.Errorf("invalid user_id: %w", err)}
+	}
+	if _, err := cfg.db.GetUserFromID(ctx, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return permanentError{fmt.Errorf("unknown user %s", userID)}
+		}
+		return err
+	}
+
+	err = cfg.applySubscriptionEvent(ctx, event.Event, userID, payload.Data.Plan, payload.Data.CurrentPeriodEnd)
+	switch {
+	case errors.Is(err, errUnknownEvent):	//Events we don't use are done with
+		return nil
+	case errors.Is(err, errNoSubscription):
+		fmt.Printf("Polka sent %s for user %s, who has no subscription\n", event.Event, userID)
+	case err != nil:
+		return err
+	}
+
+	if err := cfg.db.RecordSubscriptionEvent(ctx, database.RecordSubscriptionEventParams{
+		ID: uuid.New(),
+		UserID: userID,
+		Event: event.Event,
+		Payload: event.Payload,
+	}); err != nil {
+		fmt.Printf("Error recording subscription event for user %s: %s\n", userID, err)
+	}
+	cfg.recordAudit(ctx, auditEntry{	//Polka is the actor here, so there is no user, IP or user agent to record
+		Action: auditSubscription + "." + event.Event,
+		TargetType: "user",
+		TargetID: userID.String(),
+		Details: map[string]any{"webhook_event_id": event.EventID, "plan": payload.Data.Plan},
+	}, "", "")
+	return nil
+}
+
+func (cfg *apiConfig) finishWebhookEvent(ctx context.Context, event database.WebhookEvent, err error) {	//Marks an event done, schedules a retry, or dead-letters it
+	var updateErr error
+	switch {
+	case err == nil:
+		updateErr = cfg.db.MarkWebhookEventProcessed(ctx, event.ID)
+	case errors.As(err, &permanentError{}) || event.Attempts >= webhookMaxAttempts:
+		fmt.Printf("Webhook event %s failed for good after %d attempts: %s\n", event.EventID, event.Attempts, err)
+		updateErr = cfg.db.DeadLetterWebhookEvent(ctx, database.DeadLetterWebhookEventParams{
+			LastError: sql.NullString{String: err.Error(), Valid: true},
+			ID: event.ID,
+		})
+	default:
+		updateErr = cfg.db.RetryWebhookEvent(ctx, database.RetryWebhookEventParams{
+			LastError: sql.NullString{String: err.Error(), Valid: true},
+			NextAttemptAt: time.Now().Add(webhookBackoff(event.Attempts)),
+			ID: event.ID,
+		})
+	}
+	if updateErr != nil {	//The lease runs out and the event is tried again
+		fmt.Printf("Error updating webhook event %s: %s\n", event.EventID, updateErr)
+	}
+}
+
+type WebhookEvent struct {
+	ID uuid.UUID `json:"id"`
+	EventID string `json:"event_id"`
+	Event string `json:"event"`
+	Status string `json:"status"`
+	Attempts int32 `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError string `json:"last_error,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+	CreatedAt time.Time `json:"created_at"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+}
+
+func toWebhookEvent(e database.WebhookEvent) WebhookEvent {
+	event := WebhookEvent{
+		ID: e.ID,
+		EventID: e.EventID,
+		Event: e.Event,
+		Status: e.Status,
+		Attempts: e.Attempts,
+		NextAttemptAt: e.NextAttemptAt,
+		LastError: e.LastError.String,
+		Payload: json.RawMessage(e.Payload),
+		CreatedAt: e.CreatedAt,
+	}
+	if !json.Valid(event.Payload) {	//Keeps one bad payload from breaking the whole listing
+		event.Payload, _ = json.Marshal(e.Payload)
+	}
+	if e.ProcessedAt.Valid {
+		event.ProcessedAt = &e.ProcessedAt.Time
+	}
+	return event
+}
+
+func (cfg *apiConfig) listWebhookEventsHandler(w http.ResponseWriter, req *http.Request) {	//Lists webhook events in one status, dead-lettered ones by default
+	status := req.URL.Query().Get("status")
+	if status == "" {
+		status = webhookDead
+	}
+	if status != webhookPending && status != webhookProcessed && status != webhookDead {
+		respondWithError(w, 400, "Status must be pending, processed or dead")
+		return
+	}
+	limit := 50
+	if v := req.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			respondWithError(w, 400, "Limit must be between 1 and 500")
+			return
+		}
+		limit = n
+	}
+
+	events, err := cfg.db.ListWebhookEvents(req.Context(), database.ListWebhookEventsParams{
+		Status: status,
+		Limit: int32(limit),
+	})
+	if err != nil {
+		respondWithError(w, 500, "Error listing webhook events")
+		return
+	}
+	res := make([]WebhookEvent, 0, len(events))
+	for _, e := range events {
+		res = append(res, toWebhookEvent(e))
+	}
+	respondWithJSON(w, 200, res)
+}
+
+func (cfg *apiConfig) getWebhookEventHandler(w http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("eventId"))
+	if err != nil {
+		respondWithError(w, 404, "Webhook event not found")
+		return
+	}
+	event, err := cfg.db.GetWebhookEvent(req.Context(), id)
+	if err != nil {
+		respondWithError(w, 404, "Webhook event not found")
+		return
+	}
+	respondWithJSON(w, 200, toWebhookEvent(event))
+}
+
+func (cfg *apiConfig) replayWebhookEventHandler(w http.ResponseWriter, req *http.Request) {	//Puts a dead or processed event back on the queue with a fresh set of attempts
+	id, err := uuid.Parse(req.PathValue("eventId"))
+	if err != nil {
+		respondWithError(w, 404, "Webhook event not found")
+		return
+	}
+	if _, err := cfg.db.GetWebhookEvent(req.Context(), id); err != nil {
+		respondWithError(w, 404, "Webhook event not found")
+		return
+	}
+	replayed, err := cfg.db.ReplayWebhookEvent(req.Context(), id)
+	if err != nil {
+		respondWithError(w, 500, "Error replaying webhook event")
+		return
+	}
+	if replayed == 0 {
+		respondWithError(w, 409, "Webhook event is already queued")
+		return
+	}
+	cfg.audit(req, auditEntry{Action: auditAdminWebhookReplay, TargetType: "webhook_event", TargetID: id.String()})
+	cfg.wakeWebhookWorker()
+	w.WriteHeader(202)
+}
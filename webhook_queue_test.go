@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jms-guy/chirpy/internal/database"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := map[int32]time.Duration{
+		1: 30 * time.Second,
+		2: 1 * time.Minute,
+		4: 4 * time.Minute,
+		20: webhookMaxBackoff,
+	}
+	for attempts, want := range tests {
+		if got := webhookBackoff(attempts); got != want {
+			t.Errorf("attempt %d: got %s, wanted %s", attempts, got, want)
+		}
+	}
+}
+
+func TestPermanentError(t *testing.T) {
+	err := error(permanentError{errors.New("unknown user")})
+	if !errors.As(err, &permanentError{}) {
+		t.Error("permanent error not recognised")
+	}
+	if errors.As(errors.New("connection refused"), &permanentError{}) {
+		t.Error("ordinary error treated as permanent")
+	}
+}
+
+func TestToWebhookEvent(t *testing.T) {
+	event := toWebhookEvent(database.WebhookEvent{
+		Status: webhookDead,
+		Payload: "not json",
+		LastError: sql.NullString{String: "error decoding payload", Valid: true},
+	})
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("bad payload broke encoding: %s", err)
+	}
+	if !json.Valid(data) || event.ProcessedAt != nil || event.LastError == "" {
+		t.Errorf("unexpected event %s", data)
+	}
+}